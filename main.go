@@ -1,28 +1,33 @@
 package main
 
 import (
-	"fmt"
-	"io/ioutil"
+	"context"
+	"expvar"
 	"net/http"
-	"net/url"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"syscall"
 
 	log "github.com/Sirupsen/logrus"
-	v1 "k8s.io/client-go/1.4/pkg/api/v1"
 
 	"github.com/gravitational/trace"
 	"gopkg.in/alecthomas/kingpin.v2"
 
 	"github.com/gravitational/mm/pkg/constants"
+	"github.com/gravitational/mm/pkg/file"
+	"github.com/gravitational/mm/pkg/graphite"
 	"github.com/gravitational/mm/pkg/influxdb"
 	"github.com/gravitational/mm/pkg/kubernetes"
+	"github.com/gravitational/mm/pkg/opentsdb"
 	"github.com/gravitational/mm/pkg/prometheus"
+	"github.com/gravitational/mm/pkg/relabel"
+	"github.com/gravitational/mm/pkg/remotewrite"
+	"github.com/gravitational/mm/pkg/scrape"
+	"github.com/gravitational/mm/pkg/sink"
 	"github.com/gravitational/mm/pkg/util"
-	influx "github.com/influxdata/influxdb/client/v2"
-	watch "k8s.io/client-go/1.4/pkg/watch"
 )
 
 func main() {
@@ -53,6 +58,26 @@ func argParse() constants.CommandLineFlags {
 	kingpin.Flag(constants.FlagMetricsServicesLabelSelector, "Kubernetes label selector for metrics services.").
 		PlaceHolder("KEY:VALUE").
 		StringMapVar(&cfg.MetricsServicesLabelSelector)
+	kingpin.Flag(constants.FlagMetricsPodsLabelSelector, "Kubernetes label selector for metrics pods.").
+		PlaceHolder("KEY:VALUE").
+		StringMapVar(&cfg.MetricsPodsLabelSelector)
+	kingpin.Flag(constants.FlagMetricsNodesLabelSelector, "Kubernetes label selector for metrics nodes.").
+		PlaceHolder("KEY:VALUE").
+		StringMapVar(&cfg.MetricsNodesLabelSelector)
+	kingpin.Flag(constants.FlagNodeTarget, "Metrics endpoint scraped on every node, may be repeated.").
+		Default(constants.DefaultNodeTarget).
+		PlaceHolder("SCHEME://:PORT/PATH").
+		StringsVar(&cfg.NodeTargets)
+	kingpin.Flag(constants.FlagNodeInsecureSkipVerify, "Don't verify certificates of HTTPS node endpoints.").
+		BoolVar(&cfg.NodeInsecureSkipVerify)
+	kingpin.Flag(constants.FlagDiscovery, "Discovery role, one of services, endpoints, pods or nodes.").
+		Default(kubernetes.RoleServices).
+		Envar(constants.EnvDiscovery).
+		EnumVar(&cfg.Discovery, kubernetes.Roles...)
+	kingpin.Flag(constants.FlagAddressing,
+		"How targets are reached, one of nodeport, clusterip, dns, direct or proxy, depends on discovery role by default.").
+		Envar(constants.EnvAddressing).
+		StringVar(&cfg.Addressing)
 	kingpin.Flag(constants.FlagInfluxDBServiceNamespace, "Kubernetes namespace for InfluxDB.").
 		Default(constants.DefaultNamespace).
 		Envar(constants.EnvInfluxDBServiceNamespace).
@@ -64,13 +89,242 @@ func argParse() constants.CommandLineFlags {
 	kingpin.Flag(constants.FlagInfluxDBDatabaseName, "InfluxDB database name.").
 		Envar(constants.EnvInfluxDBDatabaseName).
 		StringVar(&cfg.InfluxDBDatabaseName)
+	kingpin.Flag(constants.FlagInfluxDBURL, "InfluxDB HTTP API URL, InfluxDB service is looked up if empty.").
+		PlaceHolder("http(s)://HOST:PORT").
+		Envar(constants.EnvInfluxDBURL).
+		StringVar(&cfg.InfluxDBURL)
+	kingpin.Flag(constants.FlagInfluxDBVersion, "Version of InfluxDB write API, 1 or 2.").
+		Default(influxdb.Version1).
+		Envar(constants.EnvInfluxDBVersion).
+		EnumVar(&cfg.InfluxDBVersion, influxdb.Versions...)
+	kingpin.Flag(constants.FlagInfluxDBOrg, "InfluxDB 2.x organization.").
+		Envar(constants.EnvInfluxDBOrg).
+		StringVar(&cfg.InfluxDBOrg)
+	kingpin.Flag(constants.FlagInfluxDBBucket, "InfluxDB 2.x bucket.").
+		Envar(constants.EnvInfluxDBBucket).
+		StringVar(&cfg.InfluxDBBucket)
+	kingpin.Flag(constants.FlagInfluxDBPrecision, "Precision of timestamps written to InfluxDB 2.x, ns, us, ms or s.").
+		Default(influxdb.PrecisionNanoseconds).
+		Envar(constants.EnvInfluxDBPrecision).
+		EnumVar(&cfg.InfluxDBPrecision, influxdb.Precisions...)
+	kingpin.Flag(constants.FlagInfluxDBUDPAddr,
+		"Address of InfluxDB UDP listener, written to instead of HTTP API if set.").
+		Envar(constants.EnvInfluxDBUDPAddr).
+		StringVar(&cfg.InfluxDBUDPAddr)
+	kingpin.Flag(constants.FlagInfluxDBUDPPayloadSize,
+		"Maximum payload size of a datagram sent to InfluxDB UDP listener.").
+		Default(strconv.Itoa(influxdb.DefaultUDPPayloadSize)).
+		Envar(constants.EnvInfluxDBUDPPayloadSize).
+		IntVar(&cfg.InfluxDBUDPPayloadSize)
+	kingpin.Flag(constants.FlagInfluxDBUsername, "InfluxDB username.").
+		Envar(constants.EnvInfluxDBUsername).
+		StringVar(&cfg.InfluxDBUsername)
+	kingpin.Flag(constants.FlagInfluxDBPassword, "InfluxDB password.").
+		Envar(constants.EnvInfluxDBPassword).
+		StringVar(&cfg.InfluxDBPassword)
+	kingpin.Flag(constants.FlagInfluxDBToken, "InfluxDB auth token, used instead of username and password.").
+		Envar(constants.EnvInfluxDBToken).
+		StringVar(&cfg.InfluxDBToken)
+	kingpin.Flag(constants.FlagInfluxDBSecret,
+		"Kubernetes secret with InfluxDB username, password or token keys, used unless set by flags.").
+		PlaceHolder("NAMESPACE/NAME").
+		Envar(constants.EnvInfluxDBSecret).
+		StringVar(&cfg.InfluxDBSecret)
+	kingpin.Flag(constants.FlagInfluxDBRoute,
+		"Write points having the tag value to the InfluxDB database and retention policy, can be repeated.").
+		PlaceHolder("TAG=VALUE:DATABASE[/RP]").
+		Envar(constants.EnvInfluxDBRoutes).
+		StringsVar(&cfg.InfluxDBRoutes)
+	kingpin.Flag(constants.FlagInfluxDBCreateDatabase, "Create InfluxDB databases not found on write.").
+		Envar(constants.EnvInfluxDBCreateDatabase).
+		BoolVar(&cfg.InfluxDBCreateDatabase)
+	kingpin.Flag(constants.FlagInfluxDBCAFile, "CA certificates verifying InfluxDB certificate.").
+		ExistingFileVar(&cfg.InfluxDBCAFile)
+	kingpin.Flag(constants.FlagInfluxDBCertFile, "Client certificate for InfluxDB.").
+		ExistingFileVar(&cfg.InfluxDBCertFile)
+	kingpin.Flag(constants.FlagInfluxDBKeyFile, "Client certificate key for InfluxDB.").
+		ExistingFileVar(&cfg.InfluxDBKeyFile)
+	kingpin.Flag(constants.FlagInfluxDBInsecureSkipVerify, "Don't verify InfluxDB certificate.").
+		BoolVar(&cfg.InfluxDBInsecureSkipVerify)
+	kingpin.Flag(constants.FlagBatchSize, "Maximum number of points in a single write to a sink.").
+		Default(strconv.Itoa(constants.DefaultBatchSize)).
+		Envar(constants.EnvBatchSize).
+		IntVar(&cfg.BatchSize)
+	kingpin.Flag(constants.FlagFlushInterval, "Maximum time points are buffered before writing to a sink.").
+		Default(constants.DefaultFlushInterval.String()).
+		Envar(constants.EnvFlushInterval).
+		DurationVar(&cfg.FlushInterval)
+	kingpin.Flag(constants.FlagMaxBufferedPoints, "Maximum number of points buffered for each sink.").
+		Default(strconv.Itoa(constants.DefaultMaxBufferedPoints)).
+		Envar(constants.EnvMaxBufferedPoints).
+		IntVar(&cfg.MaxBufferedPoints)
+	kingpin.Flag(constants.FlagDropPolicy, "Which points are dropped when the buffer is full, oldest or newest.").
+		Default(sink.DropOldest).
+		Envar(constants.EnvDropPolicy).
+		EnumVar(&cfg.DropPolicy, sink.DropPolicies...)
+	kingpin.Flag(constants.FlagSink, "Sink scraped metrics are written to, may be repeated to write to several sinks.").
+		Default(constants.SinkInfluxDB).
+		Envar(constants.EnvSinks).
+		EnumsVar(&cfg.Sinks, constants.Sinks...)
+	kingpin.Flag(constants.FlagGraphiteAddr, "Address of Graphite plaintext listener of graphite sink.").
+		PlaceHolder("HOST:PORT").
+		Envar(constants.EnvGraphiteAddr).
+		StringVar(&cfg.GraphiteAddr)
+	kingpin.Flag(constants.FlagGraphiteTemplate, "Template of Graphite path with Name, Field and Tags of a value.").
+		Default(graphite.DefaultTemplate).
+		Envar(constants.EnvGraphiteTemplate).
+		StringVar(&cfg.GraphiteTemplate)
+	kingpin.Flag(constants.FlagOpenTSDBURL, "OpenTSDB HTTP API URL of opentsdb sink.").
+		Envar(constants.EnvOpenTSDBURL).
+		StringVar(&cfg.OpenTSDBURL)
+	kingpin.Flag(constants.FlagFilePath, "Path of the file of file sink, points are written to stdout if it is -.").
+		Default(file.Stdout).
+		Envar(constants.EnvFilePath).
+		StringVar(&cfg.FilePath)
+	kingpin.Flag(constants.FlagFileFormat, "Format of points written by file sink, line or json.").
+		Default(file.FormatLine).
+		Envar(constants.EnvFileFormat).
+		EnumVar(&cfg.FileFormat, file.Formats...)
+	kingpin.Flag(constants.FlagFileMaxSize, "Size of the file of file sink it is rotated at, disabled if 0.").
+		Default("0").
+		Envar(constants.EnvFileMaxSize).
+		BytesVar(&cfg.FileMaxSize)
+	kingpin.Flag(constants.FlagFileMaxAge, "Age of the file of file sink it is rotated at, disabled if 0.").
+		Default("0").
+		Envar(constants.EnvFileMaxAge).
+		DurationVar(&cfg.FileMaxAge)
+	kingpin.Flag(constants.FlagFileGzip, "Compress files rotated by file sink with gzip.").
+		BoolVar(&cfg.FileGzip)
+	kingpin.Flag(constants.FlagRemoteWriteURL, "Prometheus remote write endpoint of remote-write sink.").
+		Envar(constants.EnvRemoteWriteURL).
+		StringVar(&cfg.RemoteWriteURL)
+	kingpin.Flag(constants.FlagRemoteWriteBearerToken, "Bearer token authenticating remote writes.").
+		Envar(constants.EnvRemoteWriteBearerToken).
+		StringVar(&cfg.RemoteWriteBearerToken)
+	kingpin.Flag(constants.FlagRemoteWriteUsername, "Username authenticating remote writes.").
+		Envar(constants.EnvRemoteWriteUsername).
+		StringVar(&cfg.RemoteWriteUsername)
+	kingpin.Flag(constants.FlagRemoteWritePassword, "Password authenticating remote writes.").
+		Envar(constants.EnvRemoteWritePassword).
+		StringVar(&cfg.RemoteWritePassword)
+	kingpin.Flag(constants.FlagRemoteWriteHeader, "Header added to remote writes, may be repeated.").
+		PlaceHolder("NAME:VALUE").
+		StringMapVar(&cfg.RemoteWriteHeaders)
+	kingpin.Flag(constants.FlagRemoteWriteInsecureSkipVerify, "Don't verify certificate of remote write endpoint.").
+		BoolVar(&cfg.RemoteWriteInsecureSkipVerify)
+	kingpin.Flag(constants.FlagWALDir,
+		"Directory to persist points in until they are written to sinks, disabled if empty.").
+		Envar(constants.EnvWALDir).
+		StringVar(&cfg.WALDir)
+	kingpin.Flag(constants.FlagWALMaxSize,
+		"Maximum disk size of WAL of each sink, the oldest points are evicted when it is reached.").
+		Default(constants.DefaultWALMaxSize).
+		Envar(constants.EnvWALMaxSize).
+		BytesVar(&cfg.WALMaxSize)
+	kingpin.Flag(constants.FlagMaxRetries, "Maximum number of retries of a failed write to a sink.").
+		Default(strconv.Itoa(constants.DefaultMaxRetries)).
+		Envar(constants.EnvMaxRetries).
+		IntVar(&cfg.MaxRetries)
+	kingpin.Flag(constants.FlagRetryBackoff, "Delay before the first retry of a failed write to a sink.").
+		Default(constants.DefaultRetryBackoff.String()).
+		Envar(constants.EnvRetryBackoff).
+		DurationVar(&cfg.RetryBackoff)
+	kingpin.Flag(constants.FlagMaxRetryBackoff, "Maximum delay between retries of a failed write to a sink.").
+		Default(constants.DefaultMaxRetryBackoff.String()).
+		Envar(constants.EnvMaxRetryBackoff).
+		DurationVar(&cfg.MaxRetryBackoff)
+	kingpin.Flag(constants.FlagScrapeInterval, "Interval between two scrapes of the same metrics service.").
+		Default(constants.DefaultScrapeInterval.String()).
+		Envar(constants.EnvScrapeInterval).
+		DurationVar(&cfg.ScrapeInterval)
+	kingpin.Flag(constants.FlagScrapeTimeout, "Timeout of a single scrape of a metrics service.").
+		Default(constants.DefaultScrapeTimeout.String()).
+		Envar(constants.EnvScrapeTimeout).
+		DurationVar(&cfg.ScrapeTimeout)
+	kingpin.Flag(constants.FlagRelabelConfig,
+		"YAML file with relabel_configs applied to targets and metric_relabel_configs applied to metrics.").
+		Envar(constants.EnvRelabelConfig).
+		ExistingFileVar(&cfg.RelabelConfig)
+	kingpin.Flag(constants.FlagMetadataTag,
+		"Metadata of discovered objects added to points as tags, all of them by default, may be repeated.").
+		Envar(constants.EnvMetadataTags).
+		EnumsVar(&cfg.MetadataTags, kubernetes.Metadata...)
+	kingpin.Flag(constants.FlagMetadataLabel, "Label of services and pods added to points as tag, may be repeated.").
+		PlaceHolder("NAME").
+		Envar(constants.EnvMetadataLabels).
+		StringsVar(&cfg.MetadataLabels)
+	kingpin.Flag(constants.FlagMetadataAnnotation,
+		"Annotation of services and pods added to points as tag, may be repeated.").
+		PlaceHolder("NAME").
+		Envar(constants.EnvMetadataAnnotations).
+		StringsVar(&cfg.MetadataAnnotations)
+	kingpin.Flag(constants.FlagHonorLabels,
+		"Keep labels of scraped metrics conflicting with tags instead of renaming them with exported_ prefix.").
+		Envar(constants.EnvHonorLabels).
+		BoolVar(&cfg.HonorLabels)
+	kingpin.Flag(constants.FlagMetricsInclude,
+		"Scrape only metric families matching the glob or /regex/, may be repeated.").
+		PlaceHolder("PATTERN").
+		Envar(constants.EnvMetricsInclude).
+		StringsVar(&cfg.MetricsInclude)
+	kingpin.Flag(constants.FlagMetricsExclude, "Skip metric families matching the glob or /regex/, may be repeated.").
+		PlaceHolder("PATTERN").
+		Envar(constants.EnvMetricsExclude).
+		StringsVar(&cfg.MetricsExclude)
+	kingpin.Flag(constants.FlagSchema,
+		"Mapping of metrics to points: typed, value or shared measurement with a field per metric.").
+		Default(prometheus.SchemaTyped).
+		Envar(constants.EnvSchema).
+		EnumVar(&cfg.Schema, prometheus.Schemas...)
+	kingpin.Flag(constants.FlagSchemaBucketTags,
+		"Write quantiles and histogram buckets of typed schema with quantile and le tags instead of fields.").
+		Envar(constants.EnvSchemaBucketTags).
+		BoolVar(&cfg.SchemaBucketTags)
+	kingpin.Flag(constants.FlagMeasurementPrefix, "Prefix of names of measurements.").
+		Envar(constants.EnvMeasurementPrefix).
+		StringVar(&cfg.MeasurementPrefix)
+	kingpin.Flag(constants.FlagSharedMeasurementTag,
+		"Tag naming the shared measurement of metrics having it, prometheus is used otherwise.").
+		Default(constants.TagService).
+		Envar(constants.EnvSharedMeasurementTag).
+		StringVar(&cfg.SharedMeasurementTag)
+	kingpin.Flag(constants.FlagRate,
+		"Write per-second rates of counter families matching the glob or /regex/, may be repeated.").
+		PlaceHolder("PATTERN").
+		Envar(constants.EnvRate).
+		StringsVar(&cfg.Rate)
+	kingpin.Flag(constants.FlagDelta,
+		"Write increases since the previous scrape of counter families matching the glob or /regex/, may be repeated.").
+		PlaceHolder("PATTERN").
+		Envar(constants.EnvDelta).
+		StringsVar(&cfg.Delta)
+	kingpin.Flag(constants.FlagKeepCounters, "Write values of counters converted to rates or increases as well.").
+		Envar(constants.EnvKeepCounters).
+		BoolVar(&cfg.KeepCounters)
+	kingpin.Flag(constants.FlagResyncPeriod, "Period of full relist of metrics services.").
+		Default(constants.DefaultResyncPeriod.String()).
+		Envar(constants.EnvResyncPeriod).
+		DurationVar(&cfg.ResyncPeriod)
+	kingpin.Flag(constants.FlagDebugListenAddr, "Address to serve internal counters at /debug/vars, disabled if empty.").
+		PlaceHolder("HOST:PORT").
+		Envar(constants.EnvDebugListenAddr).
+		StringVar(&cfg.DebugListenAddr)
 
 	kingpin.Parse()
 	return cfg
 }
 
 func run(cfg constants.CommandLineFlags) error {
-	log.Infof("Starting with config %+v", cfg)
+	log.Infof("Starting with config %+v", cfg.Redacted())
+
+	if cfg.DebugListenAddr != "" {
+		go func() {
+			// expvar registers its handler in the default mux
+			if err := http.ListenAndServe(cfg.DebugListenAddr, nil); err != nil {
+				log.Errorf("Failed to serve debug endpoint: %v", err)
+			}
+		}()
+	}
 
 	client, config, err := kubernetes.GetClient(cfg.KubeConfig)
 	if err != nil {
@@ -82,78 +336,91 @@ func run(cfg constants.CommandLineFlags) error {
 		return trace.Wrap(err, "can't create kubernetes operator instance")
 	}
 
-	nodeIP, err := op.GetNodeIP()
-	if err != nil {
-		return trace.Wrap(err, "can't get node IP address")
-	}
-
-	influxDBService, err := op.GetService(cfg.InfluxDBServiceNamespace, cfg.InfluxDBServiceName)
+	sinks, err := newSinks(cfg, op)
 	if err != nil {
-		return trace.Wrap(err, "can't find InfluxDB service")
+		return trace.Wrap(err)
 	}
+	defer sinks.Close()
 
-	influxDBAPIPort, err := kubernetes.ExtractServiceNodePort(influxDBService, constants.DefaultInfluxDBAPIPort)
+	remoteWrite, err := newRemoteWriteSink(cfg)
 	if err != nil {
-		return trace.Wrap(err, "can't find InfluxDB HTTP API port")
+		return trace.Wrap(err, "can't create %v sink", constants.SinkRemoteWrite)
 	}
-
-	u, err := url.Parse(fmt.Sprintf("http://%s:%v", nodeIP, influxDBAPIPort))
-	if err != nil {
-		return trace.Wrap(err)
+	if remoteWrite != nil {
+		defer remoteWrite.Close()
 	}
 
-	influxClient, err := influxdb.NewClient(influx.HTTPConfig{Addr: u.String()}, cfg.InfluxDBDatabaseName, "")
+	proxyTransport, err := op.ProxyTransport()
 	if err != nil {
-		return trace.Wrap(err, "can't create InfluxDB client")
+		return trace.Wrap(err, "can't create API server proxy transport")
 	}
 
-	watcher, err := op.WatchServices(cfg.MetricsServicesNamespace, cfg.MetricsServicesLabelSelector)
-	if err != nil {
-		return trace.Wrap(err, "can't watch for services labeles as %v", cfg.MetricsServicesLabelSelector)
+	scrapeConfig := scrape.Config{
+		Interval:       cfg.ScrapeInterval,
+		Timeout:        cfg.ScrapeTimeout,
+		ProxyTransport: proxyTransport,
+		HonorLabels:    cfg.HonorLabels,
+		Schema: prometheus.Schema{
+			Strategy:          cfg.Schema,
+			BucketTags:        cfg.SchemaBucketTags,
+			MeasurementPrefix: cfg.MeasurementPrefix,
+			MeasurementTag:    cfg.SharedMeasurementTag,
+		},
 	}
-	for event := range watcher.ResultChan() {
-		log.Debugf("Event: %s", event.Type)
-		if event.Type != watch.Added && event.Type != watch.Modified {
-			continue
-		}
-
-		service := event.Object.(*v1.Service)
-		metricsURL := fmt.Sprintf("http://%s:%v/metrics", nodeIP, service.Spec.Ports[0].Port)
-		log.Debugf("Fetch metrics: %s", metricsURL)
-
-		resp, err := util.DoHTTPRequest("GET", metricsURL, nil)
+	if len(cfg.MetricsInclude) > 0 || len(cfg.MetricsExclude) > 0 {
+		scrapeConfig.Filter, err = prometheus.NewFilter(cfg.MetricsInclude, cfg.MetricsExclude)
 		if err != nil {
 			return trace.Wrap(err)
 		}
-		defer resp.Body.Close()
-
-		if resp.StatusCode != http.StatusOK {
-			return trace.Errorf("%s returned HTTP status %s", metricsURL, resp.Status)
-		}
-
-		body, err := ioutil.ReadAll(resp.Body)
-		if err != nil {
-			return trace.Wrap(err, "error reading body")
-		}
-
-		metrics, err := prometheus.Parse(body, resp.Header)
+	}
+	if len(cfg.Rate) > 0 || len(cfg.Delta) > 0 {
+		scrapeConfig.Transform, err = prometheus.NewTransform(prometheus.TransformConfig{
+			Rate:    cfg.Rate,
+			Delta:   cfg.Delta,
+			KeepRaw: cfg.KeepCounters,
+		})
 		if err != nil {
-			return trace.Wrap(err, "error reading metrics for %s", metricsURL)
+			return trace.Wrap(err)
 		}
-
-		err = influxClient.Send(metrics)
+	}
+	if cfg.RelabelConfig != "" {
+		relabelFile, err := relabel.LoadFile(cfg.RelabelConfig)
 		if err != nil {
-			return trace.Wrap(err, "error sending metrics")
+			return trace.Wrap(err)
 		}
+		scrapeConfig.RelabelConfigs = relabelFile.RelabelConfigs
+		scrapeConfig.MetricRelabelConfigs = relabelFile.MetricRelabelConfigs
+	}
+	if len(sinks) > 0 {
+		scrapeConfig.Sink = sinks
+	}
+	if remoteWrite != nil {
+		scrapeConfig.SampleSink = remoteWrite
+	}
+	manager, err := scrape.NewManager(scrapeConfig)
+	if err != nil {
+		return trace.Wrap(err, "can't create scrape manager")
 	}
+	defer manager.Stop()
+	expvar.Publish("scrape_targets", expvar.Func(func() interface{} {
+		return manager.Health()
+	}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 
 	signalChan := make(chan os.Signal, 1)
 	signal.Ignore(syscall.SIGHUP, syscall.SIGPIPE)
 	signal.Notify(signalChan, syscall.SIGINT, syscall.SIGTERM)
 	go func() {
 		s := <-signalChan
-		log.Infof(fmt.Sprintf("Captured %v. Exiting...", s))
-		watcher.Stop()
+		log.Infof("Captured %v. Exiting...", s)
+		cancel()
+		manager.Stop()
+		sinks.Close()
+		if remoteWrite != nil {
+			remoteWrite.Close()
+		}
 
 		switch s {
 		case syscall.SIGINT:
@@ -163,5 +430,262 @@ func run(cfg constants.CommandLineFlags) error {
 		}
 	}()
 
+	var nodeTargets []kubernetes.NodeTarget
+	for _, spec := range cfg.NodeTargets {
+		target, err := kubernetes.ParseNodeTarget(spec)
+		if err != nil {
+			return trace.Wrap(err)
+		}
+		nodeTargets = append(nodeTargets, *target)
+	}
+
+	err = op.Discover(ctx, kubernetes.DiscoveryConfig{
+		Role:                   cfg.Discovery,
+		Namespace:              cfg.MetricsServicesNamespace,
+		LabelSelector:          cfg.MetricsServicesLabelSelector,
+		PodLabelSelector:       cfg.MetricsPodsLabelSelector,
+		NodeLabelSelector:      cfg.MetricsNodesLabelSelector,
+		NodeTargets:            nodeTargets,
+		NodeInsecureSkipVerify: cfg.NodeInsecureSkipVerify,
+		ResyncPeriod:           cfg.ResyncPeriod,
+		Addressing:             cfg.Addressing,
+		Metadata: kubernetes.MetadataConfig{
+			Tags:        cfg.MetadataTags,
+			Labels:      cfg.MetadataLabels,
+			Annotations: cfg.MetadataAnnotations,
+			Routing:     influxDBRouting(cfg),
+		},
+		Targets: manager,
+	})
+	if err != nil {
+		return trace.Wrap(err, "can't discover metrics %v", cfg.Discovery)
+	}
 	return nil
 }
+
+// newSinks creates the configured sinks, each of them buffers points
+// and retries failed writes independently of the others
+func newSinks(cfg constants.CommandLineFlags, op *kubernetes.Operator) (sink.Fanout, error) {
+	var sinks sink.Fanout
+	created := make(map[string]bool)
+	for _, name := range cfg.Sinks {
+		if created[name] {
+			continue
+		}
+		created[name] = true
+		var writer sink.Writer
+		var err error
+		switch name {
+		case constants.SinkInfluxDB:
+			writer, err = newInfluxDBClient(cfg, op)
+		case constants.SinkGraphite:
+			writer, err = graphite.NewClient(graphite.Config{
+				Addr:     cfg.GraphiteAddr,
+				Template: cfg.GraphiteTemplate,
+			})
+		case constants.SinkOpenTSDB:
+			writer, err = opentsdb.NewClient(opentsdb.Config{URL: cfg.OpenTSDBURL})
+		case constants.SinkFile:
+			writer, err = file.NewWriter(file.Config{
+				Path:    cfg.FilePath,
+				Format:  cfg.FileFormat,
+				MaxSize: int64(cfg.FileMaxSize),
+				MaxAge:  cfg.FileMaxAge,
+				Gzip:    cfg.FileGzip,
+			})
+		default:
+			// sinks of samples are created separately
+			continue
+		}
+		if err != nil {
+			sinks.Close()
+			return nil, trace.Wrap(err, "can't create %v sink", name)
+		}
+		s, err := newSink(name, cfg, writer, nil)
+		if err != nil {
+			sinks.Close()
+			return nil, trace.Wrap(err, "can't create %v sink", name)
+		}
+		sinks = append(sinks, s)
+	}
+	return sinks, nil
+}
+
+// newRemoteWriteSink creates Prometheus remote write sink if it is configured,
+// samples are buffered and retried the same way points of the other sinks are
+// but never converted to points
+func newRemoteWriteSink(cfg constants.CommandLineFlags) (sink.SampleSink, error) {
+	enabled := false
+	for _, name := range cfg.Sinks {
+		enabled = enabled || name == constants.SinkRemoteWrite
+	}
+	if !enabled {
+		return nil, nil
+	}
+	client, err := remotewrite.NewClient(remotewrite.Config{
+		URL:                cfg.RemoteWriteURL,
+		BearerToken:        cfg.RemoteWriteBearerToken,
+		Username:           cfg.RemoteWriteUsername,
+		Password:           cfg.RemoteWritePassword,
+		Headers:            cfg.RemoteWriteHeaders,
+		InsecureSkipVerify: cfg.RemoteWriteInsecureSkipVerify,
+	})
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	s, err := newSink(constants.SinkRemoteWrite, cfg, nil, client)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return s, nil
+}
+
+// newSink buffers points written to the writer or samples written to the sample
+// writer, only one of which is set, and retries failed writes
+func newSink(name string, cfg constants.CommandLineFlags, writer sink.Writer, sampleWriter sink.SampleWriter) (*sink.BatchWriter, error) {
+	var retrying interface {
+		sink.Writer
+		sink.SampleWriter
+	}
+	var err error
+	if cfg.WALDir != "" {
+		// WAL retries failed writes itself until they are written or evicted
+		retrying, err = sink.NewWAL(sink.WALConfig{
+			Name:           name,
+			Writer:         writer,
+			SampleWriter:   sampleWriter,
+			Dir:            filepath.Join(cfg.WALDir, name),
+			MaxSize:        int64(cfg.WALMaxSize),
+			InitialBackoff: cfg.RetryBackoff,
+			MaxBackoff:     cfg.MaxRetryBackoff,
+		})
+	} else {
+		retrying, err = sink.NewRetryWriter(sink.RetryConfig{
+			Name:           name,
+			Writer:         writer,
+			SampleWriter:   sampleWriter,
+			MaxRetries:     cfg.MaxRetries,
+			InitialBackoff: cfg.RetryBackoff,
+			MaxBackoff:     cfg.MaxRetryBackoff,
+		})
+	}
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	dropTags := name != constants.SinkInfluxDB && influxDBRouting(cfg)
+	if sampleWriter != nil {
+		sampleWriter = retrying
+		if dropTags {
+			sampleWriter = sink.DropLabels(sampleWriter, constants.TagMetricsDatabase, constants.TagMetricsRetentionPolicy)
+		}
+	} else {
+		writer = retrying
+		if dropTags {
+			writer = sink.DropTags(writer, constants.TagMetricsDatabase, constants.TagMetricsRetentionPolicy)
+		}
+	}
+	return sink.NewBatchWriter(sink.BatchConfig{
+		Name:              name,
+		Writer:            writer,
+		SampleWriter:      sampleWriter,
+		BatchSize:         cfg.BatchSize,
+		FlushInterval:     cfg.FlushInterval,
+		MaxBufferedPoints: cfg.MaxBufferedPoints,
+		DropPolicy:        cfg.DropPolicy,
+	})
+}
+
+// influxDBRouting returns true if points are routed to InfluxDB 1.x databases
+// by their tags, the tags are not written to the other sinks
+func influxDBRouting(cfg constants.CommandLineFlags) bool {
+	for _, name := range cfg.Sinks {
+		if name == constants.SinkInfluxDB {
+			return cfg.InfluxDBUDPAddr == "" && cfg.InfluxDBVersion == influxdb.Version1
+		}
+	}
+	return false
+}
+
+// newInfluxDBClient returns InfluxDB client at the configured URL or
+// at NodePort of InfluxDB service
+func newInfluxDBClient(cfg constants.CommandLineFlags, op *kubernetes.Operator) (sink.Writer, error) {
+	var routes []influxdb.Route
+	for _, spec := range cfg.InfluxDBRoutes {
+		route, err := influxdb.ParseRoute(spec)
+		if err != nil {
+			return nil, trace.Wrap(err)
+		}
+		routes = append(routes, *route)
+	}
+	if (len(routes) > 0 || cfg.InfluxDBCreateDatabase) &&
+		(cfg.InfluxDBUDPAddr != "" || cfg.InfluxDBVersion == influxdb.Version2) {
+		return nil, trace.BadParameter("routing and creating databases are supported by InfluxDB 1.x HTTP API only")
+	}
+
+	if cfg.InfluxDBUDPAddr != "" {
+		return influxdb.NewUDPClient(influxdb.UDPConfig{
+			Addr:        cfg.InfluxDBUDPAddr,
+			PayloadSize: cfg.InfluxDBUDPPayloadSize,
+		})
+	}
+
+	var resolver influxdb.Resolver = influxdb.URLResolver(cfg.InfluxDBURL)
+	if cfg.InfluxDBURL == "" {
+		resolver = &influxdb.ServiceResolver{
+			Services:  op,
+			Namespace: cfg.InfluxDBServiceNamespace,
+			Name:      cfg.InfluxDBServiceName,
+			Port:      constants.DefaultInfluxDBAPIPort,
+		}
+	}
+	addr, err := resolver.Resolve()
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+
+	username, password, token := cfg.InfluxDBUsername, cfg.InfluxDBPassword, cfg.InfluxDBToken
+	if cfg.InfluxDBSecret != "" && username == "" && token == "" {
+		parts := strings.SplitN(cfg.InfluxDBSecret, "/", 2)
+		if len(parts) != 2 {
+			return nil, trace.BadParameter("expected secret as namespace/name, got %q", cfg.InfluxDBSecret)
+		}
+		secret, err := op.GetSecret(parts[0], parts[1])
+		if err != nil {
+			return nil, trace.Wrap(err, "can't get InfluxDB secret")
+		}
+		username = string(secret.Data[constants.SecretKeyUsername])
+		password = string(secret.Data[constants.SecretKeyPassword])
+		token = string(secret.Data[constants.SecretKeyToken])
+	}
+
+	tlsConfig := influxdb.TLSConfig{
+		CAFile:             cfg.InfluxDBCAFile,
+		CertFile:           cfg.InfluxDBCertFile,
+		KeyFile:            cfg.InfluxDBKeyFile,
+		InsecureSkipVerify: cfg.InfluxDBInsecureSkipVerify,
+	}
+	if cfg.InfluxDBVersion == influxdb.Version2 {
+		return influxdb.NewClientV2(influxdb.ConfigV2{
+			URL:       addr,
+			Org:       cfg.InfluxDBOrg,
+			Bucket:    cfg.InfluxDBBucket,
+			Token:     token,
+			Precision: cfg.InfluxDBPrecision,
+			TLS:       tlsConfig,
+		})
+	}
+	return influxdb.NewRouter(influxdb.RouterConfig{
+		Config: influxdb.Config{
+			URL:            addr,
+			Database:       cfg.InfluxDBDatabaseName,
+			Username:       username,
+			Password:       password,
+			Token:          token,
+			TLS:            tlsConfig,
+			CreateDatabase: cfg.InfluxDBCreateDatabase,
+		},
+		Routes:             routes,
+		DatabaseTag:        constants.TagMetricsDatabase,
+		RetentionPolicyTag: constants.TagMetricsRetentionPolicy,
+	})
+}
@@ -1,11 +1,86 @@
 package constants
 
+import "time"
+
 const (
 	MetricsGroup               = "metrics"
 	MetricsVersion             = "v1"
 	DefaultNamespace           = "default"
 	DefaultInfluxDBServiceName = "influxdb"
 	DefaultInfluxDBAPIPort     = 8086
+	DefaultScrapeInterval      = 30 * time.Second
+	DefaultResyncPeriod        = 5 * time.Minute
+	DefaultScrapeTimeout       = 10 * time.Second
+	DefaultMetricsPath         = "/metrics"
+	DefaultMetricsScheme       = "http"
+	DefaultBatchSize           = 5000
+	DefaultFlushInterval       = 10 * time.Second
+	DefaultMaxBufferedPoints   = 100000
+	DefaultMaxRetries          = 5
+	DefaultRetryBackoff        = time.Second
+	DefaultMaxRetryBackoff     = 30 * time.Second
+	DefaultWALMaxSize          = "1GB"
+	// DefaultNodeTarget is node-exporter running on every node
+	DefaultNodeTarget = "http://:9100/metrics"
+)
+
+// Keys of InfluxDB credentials in kubernetes secret
+const (
+	SecretKeyUsername = "username"
+	SecretKeyPassword = "password"
+	SecretKeyToken    = "token"
+)
+
+// Tags added to points of discovered targets
+const (
+	TagPod       = "pod"
+	TagPodIP     = "pod_ip"
+	TagContainer = "container"
+	TagNode      = "node"
+	// TagNodeLabelPrefix prefixes tags made of node labels
+	TagNodeLabelPrefix = "node_label_"
+	TagNamespace       = "namespace"
+	TagService         = "service"
+	TagOwnerKind       = "owner_kind"
+	TagOwnerName       = "owner_name"
+	// TagLabelPrefix and TagAnnotationPrefix prefix tags made of
+	// selected labels and annotations of services and pods
+	TagLabelPrefix      = "label_"
+	TagAnnotationPrefix = "annotation_"
+	// TagMetricsDatabase and TagMetricsRetentionPolicy select InfluxDB
+	// database and retention policy points are written to
+	TagMetricsDatabase        = "metrics_db"
+	TagMetricsRetentionPolicy = "metrics_retention_policy"
+)
+
+// Labels of services and pods selecting InfluxDB database and retention policy of their metrics
+const (
+	LabelMetricsDatabase        = "metrics-db"
+	LabelMetricsRetentionPolicy = "metrics-retention-policy"
+)
+
+// Sinks scraped metrics are written to
+const (
+	SinkInfluxDB    = "influxdb"
+	SinkRemoteWrite = "remote-write"
+	SinkGraphite    = "graphite"
+	SinkOpenTSDB    = "opentsdb"
+	SinkFile        = "file"
+)
+
+// Sinks lists supported sinks
+var Sinks = []string{SinkInfluxDB, SinkRemoteWrite, SinkGraphite, SinkOpenTSDB, SinkFile}
+
+// Annotations of services and pods configuring how they are scraped
+const (
+	AnnotationScrape         = "prometheus.io/scrape"
+	AnnotationPort           = "prometheus.io/port"
+	AnnotationPath           = "prometheus.io/path"
+	AnnotationScheme         = "prometheus.io/scheme"
+	AnnotationScrapeInterval = "mm.gravitational.io/scrape-interval"
+	AnnotationScrapeTimeout  = "mm.gravitational.io/scrape-timeout"
+	AnnotationMetricsInclude = "mm.gravitational.io/metrics-include"
+	AnnotationMetricsExclude = "mm.gravitational.io/metrics-exclude"
 )
 
 // Namespace returns a default namespace if the specified namespace is empty
@@ -1,36 +1,256 @@
 package constants
 
+import (
+	"time"
+
+	"github.com/alecthomas/units"
+)
+
 const (
 	EnvLogLevel                 = "MM_LOG_LEVEL"
 	EnvKubeConfig               = "MM_KUBE_CONFIG"
 	EnvMetricsServicesNamespace = "MM_METRICS_SERVICES_NAMESPACE"
+	EnvDiscovery                = "MM_DISCOVERY"
+	EnvAddressing               = "MM_ADDRESSING"
 	EnvInfluxDBServiceNamespace = "MM_INFLUXDB_SERVICE_NAMESPACE"
 	EnvInfluxDBServiceName      = "MM_INFLUXDB_SERVICE_NAME"
 	EnvInfluxDBDatabaseName     = "MM_INFLUXDB_DATABASE_NAME"
+	EnvInfluxDBURL              = "MM_INFLUXDB_URL"
+	EnvInfluxDBVersion          = "MM_INFLUXDB_VERSION"
+	EnvInfluxDBOrg              = "MM_INFLUXDB_ORG"
+	EnvInfluxDBBucket           = "MM_INFLUXDB_BUCKET"
+	EnvInfluxDBPrecision        = "MM_INFLUXDB_PRECISION"
+	EnvInfluxDBUDPAddr          = "MM_INFLUXDB_UDP_ADDR"
+	EnvInfluxDBUDPPayloadSize   = "MM_INFLUXDB_UDP_PAYLOAD_SIZE"
+	EnvInfluxDBUsername         = "MM_INFLUXDB_USERNAME"
+	EnvInfluxDBPassword         = "MM_INFLUXDB_PASSWORD"
+	EnvInfluxDBToken            = "MM_INFLUXDB_TOKEN"
+	EnvInfluxDBSecret           = "MM_INFLUXDB_SECRET"
+	EnvInfluxDBRoutes           = "MM_INFLUXDB_ROUTES"
+	EnvInfluxDBCreateDatabase   = "MM_INFLUXDB_CREATE_DATABASE"
+	EnvScrapeInterval           = "MM_SCRAPE_INTERVAL"
+	EnvScrapeTimeout            = "MM_SCRAPE_TIMEOUT"
+	EnvResyncPeriod             = "MM_RESYNC_PERIOD"
+	EnvDebugListenAddr          = "MM_DEBUG_LISTEN_ADDR"
+	EnvBatchSize                = "MM_BATCH_SIZE"
+	EnvFlushInterval            = "MM_FLUSH_INTERVAL"
+	EnvMaxBufferedPoints        = "MM_MAX_BUFFERED_POINTS"
+	EnvDropPolicy               = "MM_DROP_POLICY"
+	EnvMaxRetries               = "MM_MAX_RETRIES"
+	EnvRetryBackoff             = "MM_RETRY_BACKOFF"
+	EnvMaxRetryBackoff          = "MM_MAX_RETRY_BACKOFF"
+	EnvSinks                    = "MM_SINKS"
+	EnvGraphiteAddr             = "MM_GRAPHITE_ADDR"
+	EnvGraphiteTemplate         = "MM_GRAPHITE_TEMPLATE"
+	EnvOpenTSDBURL              = "MM_OPENTSDB_URL"
+	EnvFilePath                 = "MM_FILE_PATH"
+	EnvFileFormat               = "MM_FILE_FORMAT"
+	EnvFileMaxSize              = "MM_FILE_MAX_SIZE"
+	EnvFileMaxAge               = "MM_FILE_MAX_AGE"
+	EnvRemoteWriteURL           = "MM_REMOTE_WRITE_URL"
+	EnvRemoteWriteBearerToken   = "MM_REMOTE_WRITE_BEARER_TOKEN"
+	EnvRemoteWriteUsername      = "MM_REMOTE_WRITE_USERNAME"
+	EnvRemoteWritePassword      = "MM_REMOTE_WRITE_PASSWORD"
+	EnvWALDir                   = "MM_WAL_DIR"
+	EnvWALMaxSize               = "MM_WAL_MAX_SIZE"
+	EnvRelabelConfig            = "MM_RELABEL_CONFIG"
+	EnvMetadataTags             = "MM_METADATA_TAGS"
+	EnvMetadataLabels           = "MM_METADATA_LABELS"
+	EnvMetadataAnnotations      = "MM_METADATA_ANNOTATIONS"
+	EnvHonorLabels              = "MM_HONOR_LABELS"
+	EnvMetricsInclude           = "MM_METRICS_INCLUDE"
+	EnvMetricsExclude           = "MM_METRICS_EXCLUDE"
+	EnvSchema                   = "MM_SCHEMA"
+	EnvSchemaBucketTags         = "MM_SCHEMA_BUCKET_TAGS"
+	EnvMeasurementPrefix        = "MM_MEASUREMENT_PREFIX"
+	EnvSharedMeasurementTag     = "MM_SHARED_MEASUREMENT_TAG"
+	EnvRate                     = "MM_RATE"
+	EnvDelta                    = "MM_DELTA"
+	EnvKeepCounters             = "MM_KEEP_COUNTERS"
 )
 
 const (
-	FlagLogLevel                     = "log-level"
-	FlagKubeConfig                   = "kubeconfig"
-	FlagMetricsServicesNamespace     = "metrics-services-namespace"
-	FlagMetricsServicesLabelSelector = "metrics-services-label-selector"
-	FlagInfluxDBServiceNamespace     = "influxdb-service-namespace"
-	FlagInfluxDBServiceName          = "influxdb-service-name"
-	FlagInfluxDBDatabaseName         = "influxdb-database-name"
+	FlagLogLevel                      = "log-level"
+	FlagKubeConfig                    = "kubeconfig"
+	FlagMetricsServicesNamespace      = "metrics-services-namespace"
+	FlagMetricsServicesLabelSelector  = "metrics-services-label-selector"
+	FlagMetricsPodsLabelSelector      = "metrics-pods-label-selector"
+	FlagMetricsNodesLabelSelector     = "metrics-nodes-label-selector"
+	FlagNodeTarget                    = "node-target"
+	FlagNodeInsecureSkipVerify        = "node-insecure-skip-verify"
+	FlagDiscovery                     = "discovery"
+	FlagAddressing                    = "addressing"
+	FlagInfluxDBServiceNamespace      = "influxdb-service-namespace"
+	FlagInfluxDBServiceName           = "influxdb-service-name"
+	FlagInfluxDBDatabaseName          = "influxdb-database-name"
+	FlagInfluxDBURL                   = "influxdb-url"
+	FlagInfluxDBVersion               = "influxdb-version"
+	FlagInfluxDBOrg                   = "influxdb-org"
+	FlagInfluxDBBucket                = "influxdb-bucket"
+	FlagInfluxDBPrecision             = "influxdb-precision"
+	FlagInfluxDBUDPAddr               = "influxdb-udp-addr"
+	FlagInfluxDBUDPPayloadSize        = "influxdb-udp-payload-size"
+	FlagInfluxDBUsername              = "influxdb-username"
+	FlagInfluxDBPassword              = "influxdb-password"
+	FlagInfluxDBToken                 = "influxdb-token"
+	FlagInfluxDBSecret                = "influxdb-secret"
+	FlagInfluxDBRoute                 = "influxdb-route"
+	FlagInfluxDBCreateDatabase        = "influxdb-create-database"
+	FlagInfluxDBCAFile                = "influxdb-ca-file"
+	FlagInfluxDBCertFile              = "influxdb-cert-file"
+	FlagInfluxDBKeyFile               = "influxdb-key-file"
+	FlagInfluxDBInsecureSkipVerify    = "influxdb-insecure-skip-verify"
+	FlagScrapeInterval                = "scrape-interval"
+	FlagScrapeTimeout                 = "scrape-timeout"
+	FlagResyncPeriod                  = "resync-period"
+	FlagDebugListenAddr               = "debug-listen-addr"
+	FlagBatchSize                     = "batch-size"
+	FlagFlushInterval                 = "flush-interval"
+	FlagMaxBufferedPoints             = "max-buffered-points"
+	FlagDropPolicy                    = "drop-policy"
+	FlagMaxRetries                    = "max-retries"
+	FlagRetryBackoff                  = "retry-backoff"
+	FlagMaxRetryBackoff               = "max-retry-backoff"
+	FlagSink                          = "sink"
+	FlagGraphiteAddr                  = "graphite-addr"
+	FlagGraphiteTemplate              = "graphite-template"
+	FlagOpenTSDBURL                   = "opentsdb-url"
+	FlagFilePath                      = "file-path"
+	FlagFileFormat                    = "file-format"
+	FlagFileMaxSize                   = "file-max-size"
+	FlagFileMaxAge                    = "file-max-age"
+	FlagFileGzip                      = "file-gzip"
+	FlagRemoteWriteURL                = "remote-write-url"
+	FlagRemoteWriteBearerToken        = "remote-write-bearer-token"
+	FlagRemoteWriteUsername           = "remote-write-username"
+	FlagRemoteWritePassword           = "remote-write-password"
+	FlagRemoteWriteHeader             = "remote-write-header"
+	FlagRemoteWriteInsecureSkipVerify = "remote-write-insecure-skip-verify"
+	FlagWALDir                        = "wal-dir"
+	FlagWALMaxSize                    = "wal-max-size"
+	FlagRelabelConfig                 = "relabel-config"
+	FlagMetadataTag                   = "metadata-tag"
+	FlagMetadataLabel                 = "metadata-label"
+	FlagMetadataAnnotation            = "metadata-annotation"
+	FlagHonorLabels                   = "honor-labels"
+	FlagMetricsInclude                = "metrics-include"
+	FlagMetricsExclude                = "metrics-exclude"
+	FlagSchema                        = "schema"
+	FlagSchemaBucketTags              = "schema-bucket-tags"
+	FlagMeasurementPrefix             = "measurement-prefix"
+	FlagSharedMeasurementTag          = "shared-measurement-tag"
+	FlagRate                          = "rate"
+	FlagDelta                         = "delta"
+	FlagKeepCounters                  = "keep-counters"
 )
 
 type CommandLineFlags struct {
-	LogLevel                     string
-	KubeConfig                   string
-	MetricsServicesNamespace     string
-	MetricsServicesLabelSelector map[string]string
-	InfluxDBServiceNamespace     string
-	InfluxDBServiceName          string
-	InfluxDBDatabaseName         string
+	LogLevel                      string
+	KubeConfig                    string
+	MetricsServicesNamespace      string
+	MetricsServicesLabelSelector  map[string]string
+	MetricsPodsLabelSelector      map[string]string
+	MetricsNodesLabelSelector     map[string]string
+	NodeTargets                   []string
+	NodeInsecureSkipVerify        bool
+	Discovery                     string
+	Addressing                    string
+	InfluxDBServiceNamespace      string
+	InfluxDBServiceName           string
+	InfluxDBDatabaseName          string
+	InfluxDBURL                   string
+	InfluxDBVersion               string
+	InfluxDBOrg                   string
+	InfluxDBBucket                string
+	InfluxDBPrecision             string
+	InfluxDBUDPAddr               string
+	InfluxDBUDPPayloadSize        int
+	InfluxDBUsername              string
+	InfluxDBPassword              string
+	InfluxDBToken                 string
+	InfluxDBSecret                string
+	InfluxDBRoutes                []string
+	InfluxDBCreateDatabase        bool
+	InfluxDBCAFile                string
+	InfluxDBCertFile              string
+	InfluxDBKeyFile               string
+	InfluxDBInsecureSkipVerify    bool
+	ScrapeInterval                time.Duration
+	ScrapeTimeout                 time.Duration
+	ResyncPeriod                  time.Duration
+	DebugListenAddr               string
+	BatchSize                     int
+	FlushInterval                 time.Duration
+	MaxBufferedPoints             int
+	DropPolicy                    string
+	MaxRetries                    int
+	RetryBackoff                  time.Duration
+	MaxRetryBackoff               time.Duration
+	Sinks                         []string
+	GraphiteAddr                  string
+	GraphiteTemplate              string
+	OpenTSDBURL                   string
+	FilePath                      string
+	FileFormat                    string
+	FileMaxSize                   units.Base2Bytes
+	FileMaxAge                    time.Duration
+	FileGzip                      bool
+	RemoteWriteURL                string
+	RemoteWriteBearerToken        string
+	RemoteWriteUsername           string
+	RemoteWritePassword           string
+	RemoteWriteHeaders            map[string]string
+	RemoteWriteInsecureSkipVerify bool
+	WALDir                        string
+	WALMaxSize                    units.Base2Bytes
+	RelabelConfig                 string
+	MetadataTags                  []string
+	MetadataLabels                []string
+	MetadataAnnotations           []string
+	HonorLabels                   bool
+	MetricsInclude                []string
+	MetricsExclude                []string
+	Schema                        string
+	SchemaBucketTags              bool
+	MeasurementPrefix             string
+	SharedMeasurementTag          string
+	Rate                          []string
+	Delta                         []string
+	KeepCounters                  bool
 }
 
 func NewCommandLineFlags() CommandLineFlags {
 	return CommandLineFlags{
 		MetricsServicesLabelSelector: make(map[string]string),
+		MetricsPodsLabelSelector:     make(map[string]string),
+		MetricsNodesLabelSelector:    make(map[string]string),
+		RemoteWriteHeaders:           make(map[string]string),
 	}
 }
+
+// Redacted returns a copy of the flags with secrets hidden, safe to log
+func (f CommandLineFlags) Redacted() CommandLineFlags {
+	if f.InfluxDBPassword != "" {
+		f.InfluxDBPassword = redacted
+	}
+	if f.InfluxDBToken != "" {
+		f.InfluxDBToken = redacted
+	}
+	if f.RemoteWriteBearerToken != "" {
+		f.RemoteWriteBearerToken = redacted
+	}
+	if f.RemoteWritePassword != "" {
+		f.RemoteWritePassword = redacted
+	}
+	if len(f.RemoteWriteHeaders) > 0 {
+		// headers may carry credentials
+		headers := make(map[string]string, len(f.RemoteWriteHeaders))
+		for name := range f.RemoteWriteHeaders {
+			headers[name] = redacted
+		}
+		f.RemoteWriteHeaders = headers
+	}
+	return f
+}
+
+const redacted = "<redacted>"
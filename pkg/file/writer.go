@@ -0,0 +1,240 @@
+package file
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/gravitational/trace"
+	"github.com/influxdata/influxdb/client/v2"
+)
+
+// Formats of written points
+const (
+	// FormatLine writes points in InfluxDB line protocol
+	FormatLine = "line"
+	// FormatJSON writes points as newline delimited JSON objects
+	FormatJSON = "json"
+)
+
+// Formats lists supported formats
+var Formats = []string{FormatLine, FormatJSON}
+
+// Stdout is a path writing to standard output
+const Stdout = "-"
+
+// rotatedTimeFormat is a format of the time suffix of rotated files
+const rotatedTimeFormat = "20060102T150405"
+
+type Config struct {
+	// Path is a path of the file, points are written to stdout if it is "-"
+	Path string
+	// Format is a format of written points, line protocol by default
+	Format string
+	// MaxSize rotates the file when it reaches the size in bytes, disabled if 0
+	MaxSize int64
+	// MaxAge rotates the file when it is older, disabled if 0
+	MaxAge time.Duration
+	// Gzip compresses rotated files
+	Gzip bool
+}
+
+func (c *Config) CheckAndSetDefaults() error {
+	if c.Path == "" {
+		return trace.BadParameter("missing parameter Path")
+	}
+	switch c.Format {
+	case FormatLine, FormatJSON:
+	case "":
+		c.Format = FormatLine
+	default:
+		return trace.BadParameter("unsupported format %q", c.Format)
+	}
+	if c.MaxSize < 0 || c.MaxAge < 0 {
+		return trace.BadParameter("max size and age of file should not be negative")
+	}
+	return nil
+}
+
+// Writer writes points to a file rotated by size or age, or to stdout
+type Writer struct {
+	Config
+	sync.Mutex
+	out io.Writer
+	// file is the current file, nil for stdout
+	file   *os.File
+	size   int64
+	opened time.Time
+	// compressing waits for rotated files being compressed
+	compressing sync.WaitGroup
+}
+
+func NewWriter(config Config) (*Writer, error) {
+	if err := config.CheckAndSetDefaults(); err != nil {
+		return nil, trace.Wrap(err)
+	}
+	w := &Writer{Config: config}
+	if config.Path == Stdout {
+		w.out = os.Stdout
+		return w, nil
+	}
+	if err := w.open(); err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return w, nil
+}
+
+func (w *Writer) Send(points []*client.Point) error {
+	var buf bytes.Buffer
+	for _, p := range points {
+		if err := w.encode(&buf, p); err != nil {
+			return trace.Wrap(err)
+		}
+	}
+
+	w.Lock()
+	defer w.Unlock()
+	if w.file != nil && w.shouldRotate() {
+		if err := w.rotate(); err != nil {
+			return trace.Wrap(err)
+		}
+	}
+	n, err := w.out.Write(buf.Bytes())
+	w.size += int64(n)
+	return trace.ConvertSystemError(err)
+}
+
+// encode appends the point to the buffer in the configured format
+func (w *Writer) encode(buf *bytes.Buffer, p *client.Point) error {
+	if w.Format == FormatLine {
+		buf.WriteString(p.String())
+		buf.WriteByte('\n')
+		return nil
+	}
+	fields, err := p.Fields()
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	data, err := json.Marshal(struct {
+		Name   string                 `json:"name"`
+		Tags   map[string]string      `json:"tags"`
+		Fields map[string]interface{} `json:"fields"`
+		Time   time.Time              `json:"time"`
+	}{
+		Name:   p.Name(),
+		Tags:   p.Tags(),
+		Fields: fields,
+		Time:   p.Time(),
+	})
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	buf.Write(data)
+	buf.WriteByte('\n')
+	return nil
+}
+
+func (w *Writer) shouldRotate() bool {
+	return (w.MaxSize > 0 && w.size >= w.MaxSize) ||
+		(w.MaxAge > 0 && time.Since(w.opened) >= w.MaxAge)
+}
+
+// open opens the file for appending
+func (w *Writer) open() error {
+	f, err := os.OpenFile(w.Path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return trace.ConvertSystemError(err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return trace.ConvertSystemError(err)
+	}
+	w.file, w.out = f, f
+	w.size = info.Size()
+	w.opened = time.Now()
+	return nil
+}
+
+// rotate renames the current file adding time suffix and opens a new one,
+// the current file is kept open if the new one can't be opened
+func (w *Writer) rotate() error {
+	rotated := fmt.Sprintf("%s.%s", w.Path, time.Now().UTC().Format(rotatedTimeFormat))
+	// files rotated within the same second are told apart by a counter
+	for i := 1; exists(rotated) || exists(rotated+".gz"); i++ {
+		rotated = fmt.Sprintf("%s.%s.%v", w.Path, time.Now().UTC().Format(rotatedTimeFormat), i)
+	}
+	if err := os.Rename(w.Path, rotated); err != nil {
+		return trace.ConvertSystemError(err)
+	}
+	previous := w.file
+	if err := w.open(); err != nil {
+		if renameErr := os.Rename(rotated, w.Path); renameErr != nil {
+			log.Warningf("Failed to restore %v: %v", w.Path, renameErr)
+		}
+		return trace.Wrap(err)
+	}
+	if err := previous.Close(); err != nil {
+		log.Warningf("Failed to close %v: %v", rotated, err)
+	}
+	if w.Gzip {
+		w.compressing.Add(1)
+		go func() {
+			defer w.compressing.Done()
+			if err := compress(rotated); err != nil {
+				log.Warningf("Failed to compress %v: %v", rotated, trace.UserMessage(err))
+			}
+		}()
+	}
+	return nil
+}
+
+// Close closes the file once rotated files are compressed
+func (w *Writer) Close() error {
+	w.compressing.Wait()
+	w.Lock()
+	defer w.Unlock()
+	if w.file == nil {
+		return nil
+	}
+	err := w.file.Close()
+	w.file = nil
+	return trace.ConvertSystemError(err)
+}
+
+// compress replaces the file with its gzip compressed copy
+func compress(path string) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return trace.ConvertSystemError(err)
+	}
+	defer in.Close()
+	out, err := os.OpenFile(path+".gz", os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return trace.ConvertSystemError(err)
+	}
+	gz := gzip.NewWriter(out)
+	_, err = io.Copy(gz, in)
+	if err == nil {
+		err = gz.Close()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path + ".gz")
+		return trace.ConvertSystemError(err)
+	}
+	return trace.ConvertSystemError(os.Remove(path))
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
@@ -0,0 +1,148 @@
+package graphite
+
+import (
+	"bytes"
+	"expvar"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/gravitational/trace"
+	"github.com/influxdata/influxdb/client/v2"
+
+	"github.com/gravitational/mm/pkg/sink"
+)
+
+// DefaultTemplate prefixes metric name and field with values of tags sorted by tag names
+const DefaultTemplate = "{{range $name, $value := .Tags}}{{$value}}.{{end}}{{.Name}}.{{.Field}}"
+
+// sentValues counts values sent to Graphite
+var sentValues = expvar.NewInt("graphite_sent_values")
+
+type Config struct {
+	// Addr is an address of Graphite plaintext listener, e.g. graphite.example.com:2003
+	Addr string
+	// Template is a text/template turning a value into a dotted path,
+	// it is executed with Name, Field and Tags of the value
+	Template string
+	// Timeout is a timeout of a single write
+	Timeout time.Duration
+}
+
+func (c *Config) CheckAndSetDefaults() error {
+	if c.Addr == "" {
+		return trace.BadParameter("missing parameter Addr")
+	}
+	if c.Template == "" {
+		c.Template = DefaultTemplate
+	}
+	if c.Timeout == 0 {
+		c.Timeout = sink.DefaultTimeout
+	}
+	return nil
+}
+
+// Client writes points to Graphite plaintext protocol listener over TCP,
+// every numeric field of a point is written as a separate path
+type Client struct {
+	Config
+	sync.Mutex
+	template *template.Template
+	conn     net.Conn
+}
+
+func NewClient(config Config) (*Client, error) {
+	if err := config.CheckAndSetDefaults(); err != nil {
+		return nil, trace.Wrap(err)
+	}
+	tmpl, err := template.New("path").Option("missingkey=zero").Parse(config.Template)
+	if err != nil {
+		return nil, trace.BadParameter("invalid Graphite template %q: %v", config.Template, err)
+	}
+	return &Client{Config: config, template: tmpl}, nil
+}
+
+// Send writes points in plaintext protocol, the connection is established
+// on the first write and reestablished after a failure
+func (c *Client) Send(points []*client.Point) error {
+	var buf bytes.Buffer
+	values := sink.Values(points)
+	for _, value := range values {
+		path, err := c.path(value)
+		if err != nil {
+			return trace.Wrap(err)
+		}
+		buf.WriteString(path)
+		buf.WriteByte(' ')
+		buf.WriteString(strconv.FormatFloat(value.Value, 'f', -1, 64))
+		buf.WriteByte(' ')
+		buf.WriteString(strconv.FormatInt(value.Time.Unix(), 10))
+		buf.WriteByte('\n')
+	}
+
+	c.Lock()
+	defer c.Unlock()
+	if c.conn == nil {
+		conn, err := net.DialTimeout("tcp", c.Addr, c.Timeout)
+		if err != nil {
+			return trace.ConnectionProblem(err, "failed to connect to Graphite")
+		}
+		c.conn = conn
+	}
+	c.conn.SetWriteDeadline(time.Now().Add(c.Timeout))
+	if _, err := c.conn.Write(buf.Bytes()); err != nil {
+		c.conn.Close()
+		c.conn = nil
+		return trace.ConnectionProblem(err, "failed to write to Graphite")
+	}
+	sentValues.Add(int64(len(values)))
+	return nil
+}
+
+// path returns a dotted path of the value
+func (c *Client) path(value sink.Value) (string, error) {
+	tags := make(map[string]string, len(value.Tags))
+	for name, tag := range value.Tags {
+		tags[name] = sanitize(tag)
+	}
+	var buf bytes.Buffer
+	err := c.template.Execute(&buf, struct {
+		Name  string
+		Field string
+		Tags  map[string]string
+	}{
+		Name:  sanitize(value.Name),
+		Field: sanitize(value.Field),
+		Tags:  tags,
+	})
+	if err != nil {
+		return "", trace.BadParameter("failed to execute Graphite template: %v", err)
+	}
+	return buf.String(), nil
+}
+
+// Close closes the connection
+func (c *Client) Close() error {
+	c.Lock()
+	defer c.Unlock()
+	if c.conn == nil {
+		return nil
+	}
+	err := c.conn.Close()
+	c.conn = nil
+	return trace.Wrap(err)
+}
+
+// sanitize replaces characters separating path components and lines
+func sanitize(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case '.', ' ', '\t', '\n', '/':
+			return '_'
+		}
+		return r
+	}, s)
+}
@@ -1,39 +1,305 @@
 package influxdb
 
 import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
 	"github.com/gravitational/trace"
 	"github.com/influxdata/influxdb/client/v2"
+
+	"github.com/gravitational/mm/pkg/sink"
+)
+
+// Versions of InfluxDB write API
+const (
+	// Version1 writes to a database and retention policy of InfluxDB 1.x
+	Version1 = "1"
+	// Version2 writes to a bucket of InfluxDB 2.x
+	Version2 = "2"
+)
+
+// Versions lists supported versions of InfluxDB write API
+var Versions = []string{Version1, Version2}
+
+var (
+	// writtenPoints counts points accepted by InfluxDB
+	writtenPoints = expvar.NewInt("influxdb_written_points")
+	// rejectedPoints counts points rejected by InfluxDB in partial writes
+	rejectedPoints = expvar.NewInt("influxdb_rejected_points")
 )
 
+type Config struct {
+	// URL is InfluxDB HTTP API address, e.g. https://influxdb.example.com:8086
+	URL string
+	// Database is a database points are written to
+	Database string
+	// RetentionPolicy is a retention policy of written points, optional
+	RetentionPolicy string
+	// Username and Password authenticate writes with basic auth, optional
+	Username string
+	Password string
+	// Token authenticates writes with token auth, takes precedence over username
+	Token string
+	// TLS configures connections to https URL
+	TLS TLSConfig
+	// Timeout is a timeout of a single write
+	Timeout time.Duration
+	// CreateDatabase creates the database if it does not exist
+	CreateDatabase bool
+}
+
+type TLSConfig struct {
+	// CAFile is a path to CA certificates verifying InfluxDB certificate,
+	// system CA certificates are used if empty
+	CAFile string
+	// CertFile and KeyFile are paths to client certificate and its key, optional
+	CertFile string
+	KeyFile  string
+	// InsecureSkipVerify disables verification of InfluxDB certificate
+	InsecureSkipVerify bool
+}
+
+func (c *Config) CheckAndSetDefaults() error {
+	if c.URL == "" {
+		return trace.BadParameter("missing parameter URL")
+	}
+	if c.Database == "" {
+		return trace.BadParameter("missing parameter Database")
+	}
+	if (c.TLS.CertFile == "") != (c.TLS.KeyFile == "") {
+		return trace.BadParameter("client certificate and key should be set together")
+	}
+	if c.Timeout == 0 {
+		c.Timeout = sink.DefaultTimeout
+	}
+	return nil
+}
+
+// Client writes points to InfluxDB 1.x HTTP API
 type Client struct {
-	client          client.Client
-	database        string
-	retentionPolicy string
+	Config
+	httpWriter
+	queryURL url.URL
 }
 
-func NewClient(cfg client.HTTPConfig, db string, rp string) (*Client, error) {
-	c, err := client.NewHTTPClient(cfg)
+func NewClient(config Config) (*Client, error) {
+	if err := config.CheckAndSetDefaults(); err != nil {
+		return nil, trace.Wrap(err)
+	}
+	params := url.Values{}
+	params.Set("db", config.Database)
+	if config.RetentionPolicy != "" {
+		params.Set("rp", config.RetentionPolicy)
+	}
+	params.Set("precision", "ns")
+	writer, err := newHTTPWriter(config.URL, "/write", params, config.TLS, config.Timeout)
 	if err != nil {
 		return nil, trace.Wrap(err)
 	}
-	return &Client{
-		client:          c,
-		database:        db,
-		retentionPolicy: rp,
-	}, nil
+	switch {
+	case config.Token != "":
+		writer.authorize = func(req *http.Request) { req.Header.Set("Authorization", "Token "+config.Token) }
+	case config.Username != "":
+		writer.authorize = func(req *http.Request) { req.SetBasicAuth(config.Username, config.Password) }
+	}
+	queryURL := writer.writeURL
+	queryURL.Path = strings.TrimSuffix(queryURL.Path, "/write") + "/query"
+	queryURL.RawQuery = ""
+	return &Client{Config: config, httpWriter: *writer, queryURL: queryURL}, nil
 }
 
+// Send writes the points creating the database if it is missing and
+// creation is enabled
 func (c *Client) Send(points []*client.Point) error {
-	bp, err := client.NewBatchPoints(client.BatchPointsConfig{
-		Database:        c.database,
-		RetentionPolicy: c.retentionPolicy,
-	})
-	bp.AddPoints(points)
+	err := c.httpWriter.Send(points)
+	if err == nil || !c.CreateDatabase || !isDatabaseNotFound(err) {
+		return trace.Wrap(err)
+	}
+	if err := c.createDatabase(); err != nil {
+		return trace.Wrap(err, "can't create InfluxDB database %v", c.Database)
+	}
+	log.Infof("Created InfluxDB database %v", c.Database)
+	return trace.Wrap(c.httpWriter.Send(points))
+}
+
+// createDatabase creates the database, it does nothing if the database exists
+func (c *Client) createDatabase() error {
+	params := url.Values{}
+	params.Set("q", fmt.Sprintf("CREATE DATABASE %s", quoteIdentifier(c.Database)))
+	req, err := http.NewRequest("POST", c.queryURL.String(), strings.NewReader(params.Encode()))
 	if err != nil {
 		return trace.Wrap(err)
 	}
-	if err := c.client.Write(bp); err != nil {
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if c.authorize != nil {
+		c.authorize(req)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return trace.ConnectionProblem(err, "failed to query InfluxDB")
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
 		return trace.Wrap(err)
 	}
+	if resp.StatusCode != http.StatusOK {
+		return trace.Wrap(newWriteError(resp.StatusCode, errorMessage(body)))
+	}
+	var response struct {
+		Results []struct {
+			Error string `json:"error"`
+		} `json:"results"`
+	}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return trace.Wrap(err, "unexpected InfluxDB response %q", body)
+	}
+	for _, result := range response.Results {
+		if result.Error != "" {
+			return trace.BadParameter("InfluxDB query failed: %v", result.Error)
+		}
+	}
 	return nil
 }
+
+// quoteIdentifier quotes InfluxQL identifier
+func quoteIdentifier(name string) string {
+	return `"` + strings.Replace(strings.Replace(name, `\`, `\\`, -1), `"`, `\"`, -1) + `"`
+}
+
+// httpWriter posts points in line protocol to InfluxDB write API
+type httpWriter struct {
+	writeURL url.URL
+	// precision is a precision of timestamps, nanoseconds if empty
+	precision string
+	// authorize adds credentials to the request, optional
+	authorize func(*http.Request)
+	client    *http.Client
+}
+
+// newHTTPWriter returns writer posting to the endpoint of InfluxDB API with the given URL
+func newHTTPWriter(addr, endpoint string, params url.Values,
+	tlsConfig TLSConfig, timeout time.Duration) (*httpWriter, error) {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return nil, trace.BadParameter("invalid InfluxDB URL %q: %v", addr, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, trace.BadParameter("unsupported scheme of InfluxDB URL %q", addr)
+	}
+	clientTLS, err := newTLSConfig(tlsConfig)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + endpoint
+	u.RawQuery = params.Encode()
+	return &httpWriter{
+		writeURL: *u,
+		client: &http.Client{
+			Timeout: timeout,
+			Transport: &http.Transport{
+				Proxy:           http.ProxyFromEnvironment,
+				TLSClientConfig: clientTLS,
+			},
+		},
+	}, nil
+}
+
+func (w *httpWriter) Send(points []*client.Point) error {
+	var buf bytes.Buffer
+	for _, p := range points {
+		if w.precision == "" {
+			buf.WriteString(p.String())
+		} else {
+			buf.WriteString(p.PrecisionString(w.precision))
+		}
+		buf.WriteByte('\n')
+	}
+
+	req, err := http.NewRequest("POST", w.writeURL.String(), &buf)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if w.authorize != nil {
+		w.authorize(req)
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return trace.ConnectionProblem(err, "failed to write to InfluxDB")
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		err := newWriteError(resp.StatusCode, errorMessage(body))
+		partialErr, ok := err.(*PartialWriteError)
+		if !ok {
+			return trace.Wrap(err)
+		}
+		// the rest of the points are written and should not be retried
+		rejectedPoints.Add(int64(partialErr.Dropped))
+		writtenPoints.Add(int64(len(points) - partialErr.Dropped))
+		log.Warningf("InfluxDB rejected %v of %v points of measurements %v: %v",
+			partialErr.Dropped, len(points), partialErr.Measurements, partialErr.Message)
+		return nil
+	}
+	writtenPoints.Add(int64(len(points)))
+	return nil
+}
+
+// errorMessage extracts error message from InfluxDB response body
+func errorMessage(body []byte) string {
+	var response struct {
+		// Error is set by InfluxDB 1.x
+		Error string `json:"error"`
+		// Message is set by InfluxDB 2.x
+		Message string `json:"message"`
+	}
+	if err := json.Unmarshal(body, &response); err == nil {
+		if response.Error != "" {
+			return response.Error
+		}
+		if response.Message != "" {
+			return response.Message
+		}
+	}
+	return strings.TrimSpace(string(body))
+}
+
+func newTLSConfig(config TLSConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: config.InsecureSkipVerify}
+	if config.CAFile != "" {
+		pem, err := ioutil.ReadFile(config.CAFile)
+		if err != nil {
+			return nil, trace.ConvertSystemError(err)
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, trace.BadParameter("no certificates found in %v", config.CAFile)
+		}
+	}
+	if config.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(config.CertFile, config.KeyFile)
+		if err != nil {
+			return nil, trace.Wrap(err, "can't load client certificate")
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
@@ -0,0 +1,98 @@
+package influxdb
+
+import (
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gravitational/trace"
+
+	"github.com/gravitational/mm/pkg/sink"
+)
+
+// Precisions of timestamps supported by InfluxDB 2.x write API
+const (
+	PrecisionNanoseconds  = "ns"
+	PrecisionMicroseconds = "us"
+	PrecisionMilliseconds = "ms"
+	PrecisionSeconds      = "s"
+)
+
+// Precisions lists supported precisions
+var Precisions = []string{PrecisionNanoseconds, PrecisionMicroseconds, PrecisionMilliseconds, PrecisionSeconds}
+
+// pointPrecisions maps precisions of 2.x API to precisions of line protocol encoder
+var pointPrecisions = map[string]string{
+	PrecisionNanoseconds:  "n",
+	PrecisionMicroseconds: "u",
+	PrecisionMilliseconds: "ms",
+	PrecisionSeconds:      "s",
+}
+
+type ConfigV2 struct {
+	// URL is InfluxDB HTTP API address, e.g. https://influxdb.example.com:8086
+	URL string
+	// Org is an organization name or ID owning the bucket
+	Org string
+	// Bucket is a bucket points are written to
+	Bucket string
+	// Token authenticates writes
+	Token string
+	// Precision is a precision of written timestamps, nanoseconds by default
+	Precision string
+	// TLS configures connections to https URL
+	TLS TLSConfig
+	// Timeout is a timeout of a single write
+	Timeout time.Duration
+}
+
+func (c *ConfigV2) CheckAndSetDefaults() error {
+	if c.URL == "" {
+		return trace.BadParameter("missing parameter URL")
+	}
+	if c.Org == "" {
+		return trace.BadParameter("missing parameter Org")
+	}
+	if c.Bucket == "" {
+		return trace.BadParameter("missing parameter Bucket")
+	}
+	if c.Token == "" {
+		return trace.BadParameter("missing parameter Token")
+	}
+	if c.Precision == "" {
+		c.Precision = PrecisionNanoseconds
+	}
+	if _, ok := pointPrecisions[c.Precision]; !ok {
+		return trace.BadParameter("unsupported precision %q", c.Precision)
+	}
+	if (c.TLS.CertFile == "") != (c.TLS.KeyFile == "") {
+		return trace.BadParameter("client certificate and key should be set together")
+	}
+	if c.Timeout == 0 {
+		c.Timeout = sink.DefaultTimeout
+	}
+	return nil
+}
+
+// ClientV2 writes points to InfluxDB 2.x HTTP API
+type ClientV2 struct {
+	ConfigV2
+	httpWriter
+}
+
+func NewClientV2(config ConfigV2) (*ClientV2, error) {
+	if err := config.CheckAndSetDefaults(); err != nil {
+		return nil, trace.Wrap(err)
+	}
+	params := url.Values{}
+	params.Set("org", config.Org)
+	params.Set("bucket", config.Bucket)
+	params.Set("precision", config.Precision)
+	writer, err := newHTTPWriter(config.URL, "/api/v2/write", params, config.TLS, config.Timeout)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	writer.precision = pointPrecisions[config.Precision]
+	writer.authorize = func(req *http.Request) { req.Header.Set("Authorization", "Token "+config.Token) }
+	return &ClientV2{ConfigV2: config, httpWriter: *writer}, nil
+}
@@ -0,0 +1,55 @@
+package influxdb
+
+import (
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gravitational/trace"
+
+	"github.com/gravitational/mm/pkg/sink"
+)
+
+// PartialWriteError means that InfluxDB has written some of the points
+// and rejected the rest of them
+type PartialWriteError struct {
+	sink.HTTPError
+	// Dropped is a number of rejected points
+	Dropped int
+	// Measurements are measurements of the rejected points if known
+	Measurements []string
+}
+
+var (
+	droppedRegexp     = regexp.MustCompile(`dropped=(\d+)`)
+	measurementRegexp = regexp.MustCompile(`on measurement "((?:[^"\\]|\\.)*)"`)
+)
+
+// newWriteError returns error of the response with the given status code and message
+func newWriteError(statusCode int, message string) error {
+	writeErr := sink.HTTPError{Backend: "InfluxDB", StatusCode: statusCode, Message: message}
+	// InfluxDB 2.x prefixes the message with the failed operation
+	if !strings.Contains(message, "partial write") {
+		return &writeErr
+	}
+
+	partialErr := &PartialWriteError{HTTPError: writeErr}
+	if match := droppedRegexp.FindStringSubmatch(message); match != nil {
+		partialErr.Dropped, _ = strconv.Atoi(match[1])
+	}
+	seen := make(map[string]bool)
+	for _, match := range measurementRegexp.FindAllStringSubmatch(message, -1) {
+		if !seen[match[1]] {
+			seen[match[1]] = true
+			partialErr.Measurements = append(partialErr.Measurements, match[1])
+		}
+	}
+	return partialErr
+}
+
+// isDatabaseNotFound returns true if the write failed because the database does not exist
+func isDatabaseNotFound(err error) bool {
+	writeErr, ok := trace.Unwrap(err).(*sink.HTTPError)
+	return ok && writeErr.StatusCode == http.StatusNotFound && strings.Contains(writeErr.Message, "database not found")
+}
@@ -0,0 +1,52 @@
+package influxdb
+
+import (
+	"fmt"
+
+	"github.com/gravitational/trace"
+)
+
+// Resolver finds URL of InfluxDB HTTP API
+type Resolver interface {
+	Resolve() (string, error)
+}
+
+// URLResolver resolves to the configured URL
+type URLResolver string
+
+func (r URLResolver) Resolve() (string, error) {
+	if r == "" {
+		return "", trace.BadParameter("missing InfluxDB URL")
+	}
+	return string(r), nil
+}
+
+// Services finds kubernetes services and nodes
+type Services interface {
+	// GetServiceNodePort returns NodePort of the port of the service
+	GetServiceNodePort(namespace string, name string, port int32) (int32, error)
+	GetNodeIP() (string, error)
+}
+
+// ServiceResolver finds InfluxDB by NodePort of its kubernetes service
+type ServiceResolver struct {
+	// Services finds InfluxDB service and a node to reach it through
+	Services Services
+	// Namespace and Name identify InfluxDB service
+	Namespace string
+	Name      string
+	// Port is InfluxDB HTTP API port of the service
+	Port int32
+}
+
+func (r *ServiceResolver) Resolve() (string, error) {
+	nodeIP, err := r.Services.GetNodeIP()
+	if err != nil {
+		return "", trace.Wrap(err, "can't get node IP address")
+	}
+	nodePort, err := r.Services.GetServiceNodePort(r.Namespace, r.Name, r.Port)
+	if err != nil {
+		return "", trace.Wrap(err, "can't find InfluxDB HTTP API port")
+	}
+	return fmt.Sprintf("http://%s:%v", nodeIP, nodePort), nil
+}
@@ -0,0 +1,162 @@
+package influxdb
+
+import (
+	"strings"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/gravitational/trace"
+	"github.com/influxdata/influxdb/client/v2"
+
+	"github.com/gravitational/mm/pkg/sink"
+)
+
+// Route writes points with the tag value to the database and retention policy
+type Route struct {
+	// Tag and Value match points
+	Tag   string
+	Value string
+	// Database and RetentionPolicy are where matching points are written to,
+	// the default retention policy is used if RetentionPolicy is empty
+	Database        string
+	RetentionPolicy string
+}
+
+// ParseRoute parses route from TAG=VALUE:DATABASE[/RETENTION_POLICY]
+func ParseRoute(spec string) (*Route, error) {
+	parts := strings.SplitN(spec, ":", 2)
+	match := strings.SplitN(parts[0], "=", 2)
+	if len(parts) != 2 || len(match) != 2 || match[0] == "" || parts[1] == "" {
+		return nil, trace.BadParameter("expected route as TAG=VALUE:DATABASE[/RETENTION_POLICY], got %q", spec)
+	}
+	route := &Route{Tag: match[0], Value: match[1], Database: parts[1]}
+	if i := strings.Index(parts[1], "/"); i >= 0 {
+		route.Database, route.RetentionPolicy = parts[1][:i], parts[1][i+1:]
+	}
+	if route.Value == "" {
+		return nil, trace.BadParameter("missing tag value of route %q", spec)
+	}
+	if route.Database == "" {
+		return nil, trace.BadParameter("missing database of route %q", spec)
+	}
+	return route, nil
+}
+
+type RouterConfig struct {
+	// Config configures clients, its database and retention policy
+	// are used for points not matching any route
+	Config Config
+	// Routes are evaluated in order, the first matching route is used
+	Routes []Route
+	// DatabaseTag and RetentionPolicyTag select database and retention
+	// policy of points having them, they take precedence over routes
+	DatabaseTag        string
+	RetentionPolicyTag string
+}
+
+func (c *RouterConfig) CheckAndSetDefaults() error {
+	if err := c.Config.CheckAndSetDefaults(); err != nil {
+		return trace.Wrap(err)
+	}
+	for _, route := range c.Routes {
+		if route.Tag == "" || route.Value == "" || route.Database == "" {
+			return trace.BadParameter("route should have tag, tag value and database")
+		}
+	}
+	return nil
+}
+
+// destination is a database and retention policy points are written to
+type destination struct {
+	database        string
+	retentionPolicy string
+}
+
+// Router writes points to InfluxDB databases and retention policies
+// selected by tags of points
+type Router struct {
+	RouterConfig
+	sync.Mutex
+	// clients write to each destination
+	clients map[destination]*Client
+}
+
+func NewRouter(config RouterConfig) (*Router, error) {
+	if err := config.CheckAndSetDefaults(); err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return &Router{
+		RouterConfig: config,
+		clients:      make(map[destination]*Client),
+	}, nil
+}
+
+// Send splits the points by destination and writes each part,
+// database and retention policy tags are removed from written points
+func (r *Router) Send(points []*client.Point) error {
+	var order []destination
+	parts := make(map[destination][]*client.Point)
+	for _, p := range points {
+		dest := r.route(p.Tags())
+		untagged, err := sink.WithoutTags(p, r.DatabaseTag, r.RetentionPolicyTag)
+		if err != nil {
+			log.Warningf("Skipping point %v: %v", p.Name(), err)
+			continue
+		}
+		if _, ok := parts[dest]; !ok {
+			order = append(order, dest)
+		}
+		parts[dest] = append(parts[dest], untagged)
+	}
+
+	var errors []error
+	for _, dest := range order {
+		c, err := r.client(dest)
+		if err == nil {
+			err = c.Send(parts[dest])
+		}
+		if err != nil {
+			errors = append(errors, trace.Wrap(err, "failed to write to database %v", dest.database))
+		}
+	}
+	// a single error is returned as is so that callers can inspect it
+	if len(errors) == 1 {
+		return errors[0]
+	}
+	return trace.NewAggregate(errors...)
+}
+
+// route returns destination of a point with the tags
+func (r *Router) route(tags map[string]string) destination {
+	dest := destination{database: r.Config.Database, retentionPolicy: r.Config.RetentionPolicy}
+	for _, route := range r.Routes {
+		if tags[route.Tag] == route.Value {
+			dest = destination{database: route.Database, retentionPolicy: route.RetentionPolicy}
+			break
+		}
+	}
+	if database := tags[r.DatabaseTag]; r.DatabaseTag != "" && database != "" {
+		dest = destination{database: database, retentionPolicy: tags[r.RetentionPolicyTag]}
+	} else if policy := tags[r.RetentionPolicyTag]; r.RetentionPolicyTag != "" && policy != "" {
+		dest.retentionPolicy = policy
+	}
+	return dest
+}
+
+// client returns client writing to the destination
+func (r *Router) client(dest destination) (*Client, error) {
+	r.Lock()
+	defer r.Unlock()
+	if c, ok := r.clients[dest]; ok {
+		return c, nil
+	}
+	config := r.Config
+	config.Database = dest.database
+	config.RetentionPolicy = dest.retentionPolicy
+	c, err := NewClient(config)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	r.clients[dest] = c
+	return c, nil
+}
@@ -0,0 +1,130 @@
+package influxdb
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/influxdata/influxdb/client/v2"
+
+	"github.com/gravitational/mm/pkg/sink"
+)
+
+func TestParseRoute(t *testing.T) {
+	tests := []struct {
+		spec  string
+		route *Route
+	}{
+		{spec: "namespace=kube-system:system", route: &Route{Tag: "namespace", Value: "kube-system", Database: "system"}},
+		{
+			spec:  "namespace=kube-system:system/week",
+			route: &Route{Tag: "namespace", Value: "kube-system", Database: "system", RetentionPolicy: "week"},
+		},
+		{spec: "namespace=:system"},
+		{spec: "=kube-system:system"},
+		{spec: "namespace:system"},
+		{spec: "namespace=kube-system:"},
+		{spec: "namespace=kube-system:/week"},
+		{spec: "namespace=kube-system"},
+	}
+	for _, test := range tests {
+		route, err := ParseRoute(test.spec)
+		if test.route == nil {
+			if err == nil {
+				t.Errorf("%v: expected error, got %+v", test.spec, route)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v: %v", test.spec, err)
+			continue
+		}
+		if !reflect.DeepEqual(route, test.route) {
+			t.Errorf("%v: expected %+v, got %+v", test.spec, test.route, route)
+		}
+	}
+}
+
+func TestRouterErrorsAreRetryable(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	router, err := NewRouter(RouterConfig{
+		Config:      Config{URL: server.URL, Database: "metrics"},
+		Routes:      []Route{{Tag: "namespace", Value: "kube-system", Database: "system"}},
+		DatabaseTag: "metrics_db",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		description string
+		points      []*client.Point
+	}{
+		{
+			description: "single destination",
+			points:      []*client.Point{newPoint(t, map[string]string{"namespace": "default"})},
+		},
+		{
+			description: "several destinations",
+			points: []*client.Point{
+				newPoint(t, map[string]string{"namespace": "default"}),
+				newPoint(t, map[string]string{"namespace": "kube-system"}),
+				newPoint(t, map[string]string{"metrics_db": "custom"}),
+			},
+		},
+	}
+	for _, test := range tests {
+		err := router.Send(test.points)
+		if err == nil {
+			t.Fatalf("%v: expected error", test.description)
+		}
+		if !sink.IsRetryable(err) {
+			t.Errorf("%v: expected retryable error, got %v", test.description, err)
+		}
+	}
+}
+
+func TestRouterWritesAreRetried(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	router, err := NewRouter(RouterConfig{Config: Config{URL: server.URL, Database: "metrics"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer, err := sink.NewRetryWriter(sink.RetryConfig{
+		Name:           "influxdb",
+		Writer:         router,
+		MaxRetries:     3,
+		InitialBackoff: time.Millisecond,
+		MaxBackoff:     time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Send([]*client.Point{newPoint(t, nil)}); err == nil {
+		t.Fatal("expected error")
+	}
+	if attempts := atomic.LoadInt32(&requests); attempts != 4 {
+		t.Errorf("expected 4 attempts, got %v", attempts)
+	}
+}
+
+func newPoint(t *testing.T, tags map[string]string) *client.Point {
+	p, err := client.NewPoint("up", tags, map[string]interface{}{"gauge": 1.0}, time.Unix(1, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
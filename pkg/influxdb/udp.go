@@ -0,0 +1,101 @@
+package influxdb
+
+import (
+	"bytes"
+	"expvar"
+	"net"
+
+	"github.com/gravitational/trace"
+	"github.com/influxdata/influxdb/client/v2"
+)
+
+// DefaultUDPPayloadSize is a default maximum size of a datagram payload
+// that fits into typical MTU without fragmentation
+const DefaultUDPPayloadSize = 512
+
+var (
+	// udpPoints counts points sent over UDP
+	udpPoints = expvar.NewInt("influxdb_udp_points")
+	// udpBytes counts bytes of datagrams sent over UDP
+	udpBytes = expvar.NewInt("influxdb_udp_bytes")
+)
+
+type UDPConfig struct {
+	// Addr is an address of InfluxDB UDP listener, e.g. influxdb.example.com:8089
+	Addr string
+	// PayloadSize is a maximum size of a datagram payload, a point
+	// larger than it is sent in a datagram of its own
+	PayloadSize int
+}
+
+func (c *UDPConfig) CheckAndSetDefaults() error {
+	if c.Addr == "" {
+		return trace.BadParameter("missing parameter Addr")
+	}
+	if c.PayloadSize < 0 {
+		return trace.BadParameter("payload size should be positive, got %v", c.PayloadSize)
+	}
+	if c.PayloadSize == 0 {
+		c.PayloadSize = DefaultUDPPayloadSize
+	}
+	return nil
+}
+
+// UDPClient writes points in line protocol to InfluxDB UDP listener,
+// writes are not acknowledged so points lost in transit are not noticed
+type UDPClient struct {
+	UDPConfig
+	conn *net.UDPConn
+}
+
+func NewUDPClient(config UDPConfig) (*UDPClient, error) {
+	if err := config.CheckAndSetDefaults(); err != nil {
+		return nil, trace.Wrap(err)
+	}
+	addr, err := net.ResolveUDPAddr("udp", config.Addr)
+	if err != nil {
+		return nil, trace.BadParameter("invalid InfluxDB UDP address %q: %v", config.Addr, err)
+	}
+	conn, err := net.DialUDP("udp", nil, addr)
+	if err != nil {
+		return nil, trace.ConnectionProblem(err, "failed to connect to InfluxDB UDP listener")
+	}
+	return &UDPClient{UDPConfig: config, conn: conn}, nil
+}
+
+// Send packs points into datagrams of at most the payload size
+func (c *UDPClient) Send(points []*client.Point) error {
+	var buf bytes.Buffer
+	count := 0
+	for _, p := range points {
+		line := p.String() + "\n"
+		if buf.Len() > 0 && buf.Len()+len(line) > c.PayloadSize {
+			if err := c.write(buf.Bytes(), count); err != nil {
+				return trace.Wrap(err)
+			}
+			buf.Reset()
+			count = 0
+		}
+		buf.WriteString(line)
+		count++
+	}
+	if buf.Len() > 0 {
+		return trace.Wrap(c.write(buf.Bytes(), count))
+	}
+	return nil
+}
+
+// write sends a single datagram with the given number of points
+func (c *UDPClient) write(payload []byte, count int) error {
+	if _, err := c.conn.Write(payload); err != nil {
+		return trace.ConnectionProblem(err, "failed to write to InfluxDB UDP listener")
+	}
+	udpPoints.Add(int64(count))
+	udpBytes.Add(int64(len(payload)))
+	return nil
+}
+
+// Close closes the connection
+func (c *UDPClient) Close() error {
+	return trace.Wrap(c.conn.Close())
+}
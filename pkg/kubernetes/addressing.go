@@ -0,0 +1,120 @@
+package kubernetes
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/gravitational/trace"
+
+	"k8s.io/client-go/1.4/pkg/api/unversioned"
+	"k8s.io/client-go/1.4/rest"
+)
+
+// Addressing strategies define how discovered targets are reached
+const (
+	// AddressingNodePort scrapes services through NodePort of a ready node
+	AddressingNodePort = "nodeport"
+	// AddressingClusterIP scrapes services at their ClusterIP, works in cluster only
+	AddressingClusterIP = "clusterip"
+	// AddressingDNS scrapes services at their DNS names, works in cluster only
+	AddressingDNS = "dns"
+	// AddressingDirect scrapes pods at their IPs and nodes at their addresses
+	AddressingDirect = "direct"
+	// AddressingProxy scrapes targets through API server proxy,
+	// works outside of the cluster with kubernetes client credentials
+	AddressingProxy = "proxy"
+)
+
+// Addressings lists supported addressing strategies
+var Addressings = []string{AddressingNodePort, AddressingClusterIP, AddressingDNS, AddressingDirect, AddressingProxy}
+
+// addressing defines how discovered targets are reached
+type addressing struct {
+	// strategy is one of addressing strategies
+	strategy string
+	// nodeIP is IP address of a ready node services are scraped through
+	nodeIP string
+	// nodeIPs returns IP addresses of ready nodes, nil unless NodePort addressing is used
+	nodeIPs func() ([]string, error)
+	// apiServerURL is base URL of API server proxying targets
+	apiServerURL string
+}
+
+// roleAddressings lists addressing strategies supported by every role,
+// the first one is the default
+var roleAddressings = map[string][]string{
+	RoleServices:  {AddressingNodePort, AddressingClusterIP, AddressingDNS, AddressingProxy},
+	RoleEndpoints: {AddressingDirect, AddressingProxy},
+	RolePods:      {AddressingDirect, AddressingProxy},
+	RoleNodes:     {AddressingDirect, AddressingProxy},
+}
+
+// checkAddressing returns addressing strategy of the role, the default
+// one if the addressing is empty
+func checkAddressing(role, addressing string) (string, error) {
+	supported := roleAddressings[role]
+	if addressing == "" {
+		return supported[0], nil
+	}
+	for _, a := range supported {
+		if a == addressing {
+			return addressing, nil
+		}
+	}
+	return "", trace.BadParameter("%v role supports addressing %v, got %q",
+		role, strings.Join(supported, ", "), addressing)
+}
+
+// ProxyTransport returns transport authenticating requests to API server
+// with credentials of the kubernetes client
+func (op *Operator) ProxyTransport() (http.RoundTripper, error) {
+	transport, err := rest.TransportFor(op.Config)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return transport, nil
+}
+
+// apiServerURL returns base URL of API server
+func (op *Operator) apiServerURL() (string, error) {
+	u, _, err := rest.DefaultServerURL(op.Config.Host, "", unversioned.GroupVersion{},
+		rest.IsConfigTransportTLS(*op.Config))
+	if err != nil {
+		return "", trace.Wrap(err)
+	}
+	return strings.TrimSuffix(u.String(), "/"), nil
+}
+
+// proxyURL returns URL of the path on the port of the object proxied by API server,
+// collection is a path of objects of the same kind, e.g. namespaces/default/pods
+func proxyURL(apiServerURL, collection, name, scheme string, port interface{}, path string) string {
+	var prefix string
+	if scheme == "https" {
+		prefix = "https:"
+	}
+	return fmt.Sprintf("%s/api/v1/%s/%s%s:%v/proxy%s", apiServerURL, collection, prefix, name, port, path)
+}
+
+// resolveNodeIP looks up a ready node services are scraped through,
+// the current node is kept while it is ready
+func (a *addressing) resolveNodeIP() error {
+	if a.nodeIPs == nil {
+		return nil
+	}
+	ips, err := a.nodeIPs()
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	for _, ip := range ips {
+		if ip == a.nodeIP {
+			return nil
+		}
+	}
+	if a.nodeIP != "" {
+		log.Infof("Node %v is not ready, scraping services through %v", a.nodeIP, ips[0])
+	}
+	a.nodeIP = ips[0]
+	return nil
+}
@@ -0,0 +1,96 @@
+package kubernetes
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gravitational/trace"
+
+	"github.com/gravitational/mm/pkg/constants"
+	"github.com/gravitational/mm/pkg/prometheus"
+	v1 "k8s.io/client-go/1.4/pkg/api/v1"
+)
+
+// ScrapeConfig is a scrape configuration of an object set by its annotations
+type ScrapeConfig struct {
+	// Scrape is false if the object is explicitly excluded from scraping
+	Scrape bool
+	// Port is a port number or name metrics are exposed at, empty for the default port
+	Port string
+	// Path is HTTP path metrics are exposed at
+	Path string
+	// Scheme is either http or https
+	Scheme string
+	// Interval overrides the default scrape interval if set
+	Interval time.Duration
+	// Timeout overrides the default scrape timeout if set
+	Timeout time.Duration
+	// MetricsInclude and MetricsExclude are patterns of names
+	// of scraped metric families
+	MetricsInclude []string
+	MetricsExclude []string
+}
+
+// GetScrapeConfig returns scrape configuration set by the object annotations
+func GetScrapeConfig(meta v1.ObjectMeta) (*ScrapeConfig, error) {
+	annotations := meta.Annotations
+	config := &ScrapeConfig{
+		Scrape: true,
+		Port:   annotations[constants.AnnotationPort],
+		Path:   constants.DefaultMetricsPath,
+		Scheme: constants.DefaultMetricsScheme,
+	}
+
+	if value, ok := annotations[constants.AnnotationScrape]; ok {
+		scrape, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, trace.BadParameter("invalid %v annotation %q", constants.AnnotationScrape, value)
+		}
+		config.Scrape = scrape
+	}
+
+	if path := annotations[constants.AnnotationPath]; path != "" {
+		if !strings.HasPrefix(path, "/") {
+			path = "/" + path
+		}
+		config.Path = path
+	}
+
+	if scheme := annotations[constants.AnnotationScheme]; scheme != "" {
+		scheme = strings.ToLower(scheme)
+		if scheme != "http" && scheme != "https" {
+			return nil, trace.BadParameter("unsupported %v annotation %q", constants.AnnotationScheme, scheme)
+		}
+		config.Scheme = scheme
+	}
+
+	var err error
+	config.Interval, err = parseDurationAnnotation(annotations, constants.AnnotationScrapeInterval)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	config.Timeout, err = parseDurationAnnotation(annotations, constants.AnnotationScrapeTimeout)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+
+	config.MetricsInclude = prometheus.ParsePatterns(annotations[constants.AnnotationMetricsInclude])
+	config.MetricsExclude = prometheus.ParsePatterns(annotations[constants.AnnotationMetricsExclude])
+	if _, err := prometheus.NewFilter(config.MetricsInclude, config.MetricsExclude); err != nil {
+		return nil, trace.Wrap(err, "invalid metrics filter annotation")
+	}
+	return config, nil
+}
+
+func parseDurationAnnotation(annotations map[string]string, name string) (time.Duration, error) {
+	value, ok := annotations[name]
+	if !ok {
+		return 0, nil
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration <= 0 {
+		return 0, trace.BadParameter("invalid %v annotation %q", name, value)
+	}
+	return duration, nil
+}
@@ -0,0 +1,249 @@
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gravitational/trace"
+
+	"github.com/gravitational/mm/pkg/scrape"
+)
+
+// Discovery roles define which kubernetes objects scrape targets are made of
+const (
+	// RoleServices scrapes every service once
+	RoleServices = "services"
+	// RoleEndpoints scrapes every ready address of service endpoints
+	RoleEndpoints = "endpoints"
+	// RolePods scrapes every running and ready pod
+	RolePods = "pods"
+	// RoleNodes scrapes node targets on every node
+	RoleNodes = "nodes"
+)
+
+// Roles lists supported discovery roles
+var Roles = []string{RoleServices, RoleEndpoints, RolePods, RoleNodes}
+
+// Targets receives scrape targets found by discovery
+type Targets interface {
+	// Update adds the target or updates the target with the same name
+	Update(target scrape.Target)
+	// Remove removes the target with the given name
+	Remove(name string)
+	// Sync replaces all targets with the given ones
+	Sync(targets []scrape.Target)
+}
+
+type DiscoveryConfig struct {
+	// Role defines which objects targets are made of
+	Role string
+	// Namespace is a namespace of discovered objects
+	Namespace string
+	// LabelSelector selects discovered services
+	LabelSelector map[string]string
+	// PodLabelSelector selects discovered pods
+	PodLabelSelector map[string]string
+	// NodeLabelSelector selects discovered nodes
+	NodeLabelSelector map[string]string
+	// NodeTargets are scraped on every node
+	NodeTargets []NodeTarget
+	// NodeInsecureSkipVerify disables verification of certificates of https node targets
+	NodeInsecureSkipVerify bool
+	// ResyncPeriod is a period of full relist of discovered objects
+	ResyncPeriod time.Duration
+	// Addressing defines how targets are reached, the default one of the role if empty
+	Addressing string
+	// Metadata configures tags of points made of metadata of discovered objects
+	Metadata MetadataConfig
+	// Targets receives discovered targets
+	Targets Targets
+}
+
+func (c *DiscoveryConfig) CheckAndSetDefaults() error {
+	switch c.Role {
+	case RoleServices, RoleEndpoints, RolePods, RoleNodes:
+	default:
+		return trace.BadParameter("unsupported discovery role %q", c.Role)
+	}
+	if c.ResyncPeriod <= 0 {
+		return trace.BadParameter("resync period should be positive, got %v", c.ResyncPeriod)
+	}
+	var err error
+	c.Addressing, err = checkAddressing(c.Role, c.Addressing)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	if c.Role == RoleNodes && len(c.NodeTargets) == 0 {
+		return trace.BadParameter("missing parameter NodeTargets")
+	}
+	if err := c.Metadata.CheckAndSetDefaults(); err != nil {
+		return trace.Wrap(err)
+	}
+	if c.Targets == nil {
+		return trace.BadParameter("missing parameter Targets")
+	}
+	return nil
+}
+
+// Discover finds scrape targets and keeps them up to date until
+// the context is cancelled
+func (op *Operator) Discover(ctx context.Context, config DiscoveryConfig) error {
+	if err := config.CheckAndSetDefaults(); err != nil {
+		return trace.Wrap(err)
+	}
+	registry := newRegistry(config.Targets)
+	tagger := newTagger(config.Metadata)
+	addressing := addressing{strategy: config.Addressing}
+	var err error
+	switch {
+	case addressing.strategy == AddressingNodePort:
+		// a ready node is looked up on every resync
+		addressing.nodeIPs = op.GetReadyNodeIPs
+		if err = addressing.resolveNodeIP(); err != nil {
+			return trace.Wrap(err, "can't get node IP address")
+		}
+	case addressing.strategy == AddressingProxy:
+		addressing.apiServerURL, err = op.apiServerURL()
+		if err != nil {
+			return trace.Wrap(err)
+		}
+	}
+
+	switch config.Role {
+	case RoleServices:
+		handler := &serviceDiscovery{registry: registry, addressing: addressing, tagger: tagger}
+		return trace.Wrap(op.InformServices(ctx, config.Namespace, config.LabelSelector,
+			config.ResyncPeriod, handler))
+	case RoleEndpoints:
+		discovery := newEndpointsDiscovery(registry, addressing, tagger)
+		return trace.Wrap(runAll(ctx,
+			func(ctx context.Context) error {
+				return op.InformServices(ctx, config.Namespace, config.LabelSelector,
+					config.ResyncPeriod, discovery.serviceHandler())
+			},
+			func(ctx context.Context) error {
+				return op.InformEndpoints(ctx, config.Namespace, config.LabelSelector,
+					config.ResyncPeriod, discovery.endpointsHandler())
+			},
+			func(ctx context.Context) error {
+				return op.InformPods(ctx, config.Namespace, nil, config.ResyncPeriod, discovery.podHandler())
+			},
+		))
+	case RolePods:
+		handler := &podDiscovery{registry: registry, addressing: addressing, tagger: tagger}
+		return trace.Wrap(op.InformPods(ctx, config.Namespace, config.PodLabelSelector,
+			config.ResyncPeriod, handler))
+	case RoleNodes:
+		handler := &nodeDiscovery{
+			registry:           registry,
+			addressing:         addressing,
+			tagger:             tagger,
+			targets:            config.NodeTargets,
+			bearerToken:        op.Config.BearerToken,
+			insecureSkipVerify: config.NodeInsecureSkipVerify,
+		}
+		return trace.Wrap(op.InformNodes(ctx, config.NodeLabelSelector, config.ResyncPeriod, handler))
+	}
+	return nil
+}
+
+// runAll runs the functions concurrently until all of them return,
+// the first error cancels the rest of them
+func runAll(ctx context.Context, fns ...func(ctx context.Context) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	errorC := make(chan error, len(fns))
+	for _, fn := range fns {
+		go func(fn func(ctx context.Context) error) {
+			errorC <- fn(ctx)
+		}(fn)
+	}
+
+	var errors []error
+	for range fns {
+		if err := <-errorC; err != nil {
+			errors = append(errors, err)
+			cancel()
+		}
+	}
+	return trace.NewAggregate(errors...)
+}
+
+// registry keeps targets made of every discovered object and
+// updates them when the object changes
+type registry struct {
+	sync.Mutex
+	targets Targets
+	// names are names of targets by keys of objects they are made of
+	names map[string][]string
+}
+
+func newRegistry(targets Targets) *registry {
+	return &registry{
+		targets: targets,
+		names:   make(map[string][]string),
+	}
+}
+
+// update replaces targets made of the object with the given key
+func (r *registry) update(key string, targets []scrape.Target) {
+	r.Lock()
+	defer r.Unlock()
+
+	current := make(map[string]bool, len(targets))
+	for _, target := range targets {
+		current[target.Name] = true
+		r.targets.Update(target)
+	}
+	for _, name := range r.names[key] {
+		if !current[name] {
+			r.targets.Remove(name)
+		}
+	}
+	r.setNames(key, targets)
+}
+
+// remove removes targets made of the object with the given key
+func (r *registry) remove(key string) {
+	r.Lock()
+	defer r.Unlock()
+
+	for _, name := range r.names[key] {
+		r.targets.Remove(name)
+	}
+	delete(r.names, key)
+}
+
+// sync replaces all targets with the given targets by object keys
+func (r *registry) sync(targets map[string][]scrape.Target) {
+	r.Lock()
+	defer r.Unlock()
+
+	var all []scrape.Target
+	r.names = make(map[string][]string, len(targets))
+	for key, objectTargets := range targets {
+		all = append(all, objectTargets...)
+		r.setNames(key, objectTargets)
+	}
+	r.targets.Sync(all)
+}
+
+func (r *registry) setNames(key string, targets []scrape.Target) {
+	if len(targets) == 0 {
+		delete(r.names, key)
+		return
+	}
+	names := make([]string, 0, len(targets))
+	for _, target := range targets {
+		names = append(names, target.Name)
+	}
+	r.names[key] = names
+}
+
+// objectKey returns a key of the object in the registry
+func objectKey(namespace, name string) string {
+	return fmt.Sprintf("%s/%s", namespace, name)
+}
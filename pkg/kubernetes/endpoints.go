@@ -0,0 +1,285 @@
+package kubernetes
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/gravitational/trace"
+
+	"github.com/gravitational/mm/pkg/scrape"
+	v1 "k8s.io/client-go/1.4/pkg/api/v1"
+	"k8s.io/client-go/1.4/pkg/runtime"
+)
+
+// endpointsDiscovery makes a target of every ready address of service
+// endpoints, services provide scrape configuration of their endpoints
+// and pods backing the addresses provide their labels and controllers
+type endpointsDiscovery struct {
+	sync.Mutex
+	registry   *registry
+	addressing addressing
+	tagger     tagger
+	services   map[string]*v1.Service
+	endpoints  map[string]*v1.Endpoints
+	pods       map[string]*v1.Pod
+}
+
+func newEndpointsDiscovery(registry *registry, addressing addressing, tagger tagger) *endpointsDiscovery {
+	return &endpointsDiscovery{
+		registry:   registry,
+		addressing: addressing,
+		tagger:     tagger,
+		services:   make(map[string]*v1.Service),
+		endpoints:  make(map[string]*v1.Endpoints),
+		pods:       make(map[string]*v1.Pod),
+	}
+}
+
+// serviceHandler returns handler of service events
+func (d *endpointsDiscovery) serviceHandler() EventHandler {
+	return handlerFuncs{
+		onUpdate: func(obj runtime.Object) {
+			if service, ok := obj.(*v1.Service); ok {
+				d.Lock()
+				defer d.Unlock()
+				key := objectKey(service.Namespace, service.Name)
+				d.services[key] = service
+				d.refresh(key)
+			}
+		},
+		onDelete: func(obj runtime.Object) {
+			if service, ok := obj.(*v1.Service); ok {
+				d.Lock()
+				defer d.Unlock()
+				key := objectKey(service.Namespace, service.Name)
+				delete(d.services, key)
+				d.refresh(key)
+			}
+		},
+		onSync: func(objs []runtime.Object) {
+			d.Lock()
+			defer d.Unlock()
+			d.services = make(map[string]*v1.Service, len(objs))
+			for _, obj := range objs {
+				if service, ok := obj.(*v1.Service); ok {
+					d.services[objectKey(service.Namespace, service.Name)] = service
+				}
+			}
+			d.refreshAll()
+		},
+	}
+}
+
+// endpointsHandler returns handler of endpoints events
+func (d *endpointsDiscovery) endpointsHandler() EventHandler {
+	return handlerFuncs{
+		onUpdate: func(obj runtime.Object) {
+			if endpoints, ok := obj.(*v1.Endpoints); ok {
+				d.Lock()
+				defer d.Unlock()
+				key := objectKey(endpoints.Namespace, endpoints.Name)
+				d.endpoints[key] = endpoints
+				d.refresh(key)
+			}
+		},
+		onDelete: func(obj runtime.Object) {
+			if endpoints, ok := obj.(*v1.Endpoints); ok {
+				d.Lock()
+				defer d.Unlock()
+				key := objectKey(endpoints.Namespace, endpoints.Name)
+				delete(d.endpoints, key)
+				d.refresh(key)
+			}
+		},
+		onSync: func(objs []runtime.Object) {
+			d.Lock()
+			defer d.Unlock()
+			d.endpoints = make(map[string]*v1.Endpoints, len(objs))
+			for _, obj := range objs {
+				if endpoints, ok := obj.(*v1.Endpoints); ok {
+					d.endpoints[objectKey(endpoints.Namespace, endpoints.Name)] = endpoints
+				}
+			}
+			d.refreshAll()
+		},
+	}
+}
+
+// podHandler returns handler of pod events
+func (d *endpointsDiscovery) podHandler() EventHandler {
+	return handlerFuncs{
+		onUpdate: func(obj runtime.Object) {
+			if pod, ok := obj.(*v1.Pod); ok {
+				d.Lock()
+				defer d.Unlock()
+				d.pods[objectKey(pod.Namespace, pod.Name)] = pod
+				d.refreshPod(pod.Namespace, pod.Name)
+			}
+		},
+		onDelete: func(obj runtime.Object) {
+			if pod, ok := obj.(*v1.Pod); ok {
+				d.Lock()
+				defer d.Unlock()
+				delete(d.pods, objectKey(pod.Namespace, pod.Name))
+				d.refreshPod(pod.Namespace, pod.Name)
+			}
+		},
+		onSync: func(objs []runtime.Object) {
+			d.Lock()
+			defer d.Unlock()
+			d.pods = make(map[string]*v1.Pod, len(objs))
+			for _, obj := range objs {
+				if pod, ok := obj.(*v1.Pod); ok {
+					d.pods[objectKey(pod.Namespace, pod.Name)] = pod
+				}
+			}
+			d.refreshAll()
+		},
+	}
+}
+
+// refreshPod updates targets of services with endpoints backed by the pod,
+// must be called under the lock
+func (d *endpointsDiscovery) refreshPod(namespace, name string) {
+	for key, endpoints := range d.endpoints {
+		if hasPod(endpoints, namespace, name) {
+			d.refresh(key)
+		}
+	}
+}
+
+// hasPod returns true if an address of the endpoints is backed by the pod
+func hasPod(endpoints *v1.Endpoints, namespace, name string) bool {
+	for _, subset := range endpoints.Subsets {
+		for _, address := range subset.Addresses {
+			if ref := address.TargetRef; ref != nil && ref.Kind == "Pod" && ref.Name == name &&
+				podNamespace(ref, endpoints.Namespace) == namespace {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// podNamespace returns namespace of the referenced pod, the one of endpoints if it is not set
+func podNamespace(ref *v1.ObjectReference, namespace string) string {
+	if ref.Namespace != "" {
+		return ref.Namespace
+	}
+	return namespace
+}
+
+// refresh updates targets of the service with the given key,
+// must be called under the lock
+func (d *endpointsDiscovery) refresh(key string) {
+	service, ok := d.services[key]
+	if !ok {
+		d.registry.remove(key)
+		return
+	}
+	d.registry.update(key, d.makeTargets(service, d.endpoints[key]))
+}
+
+// refreshAll updates targets of all services, must be called under the lock
+func (d *endpointsDiscovery) refreshAll() {
+	targets := make(map[string][]scrape.Target, len(d.services))
+	for key, service := range d.services {
+		targets[key] = d.makeTargets(service, d.endpoints[key])
+	}
+	d.registry.sync(targets)
+}
+
+// makeTargets returns scrape targets of ready addresses of the service endpoints
+func (d *endpointsDiscovery) makeTargets(service *v1.Service, endpoints *v1.Endpoints) []scrape.Target {
+	key := objectKey(service.Namespace, service.Name)
+	if endpoints == nil {
+		log.Debugf("No endpoints of service %v", key)
+		return nil
+	}
+	config, err := GetScrapeConfig(service.ObjectMeta)
+	if err != nil {
+		log.Warningf("Skip service %v: %v", key, trace.UserMessage(err))
+		return nil
+	}
+	if !config.Scrape {
+		log.Debugf("Service %v is excluded from scraping", key)
+		return nil
+	}
+
+	var targets []scrape.Target
+	for _, subset := range endpoints.Subsets {
+		port, err := resolveEndpointPort(service, subset, config.Port)
+		if err != nil {
+			log.Warningf("Skip endpoints of service %v: %v", key, trace.UserMessage(err))
+			continue
+		}
+		for _, address := range subset.Addresses {
+			tags := d.tagger.serviceTags(service)
+			metadata := make(map[string]string)
+			addObjectMetadata(metadata, "service", service.ObjectMeta)
+			metadata[metaLabelPrefix+"endpoints_name"] = endpoints.Name
+			metadata[metaLabelPrefix+"pod_ip"] = address.IP
+			var pod *v1.ObjectReference
+			var podName string
+			id := address.IP
+			if address.TargetRef != nil && address.TargetRef.Kind == "Pod" {
+				pod = address.TargetRef
+				podName = pod.Name
+				metadata[metaLabelPrefix+"pod_name"] = pod.Name
+				id = pod.Name
+				if object, ok := d.pods[objectKey(podNamespace(pod, service.Namespace), pod.Name)]; ok {
+					d.tagger.addPodObject(tags, object)
+					addPodMetadata(metadata, object)
+				}
+			}
+			d.tagger.addPod(tags, podName, address.IP)
+			if address.NodeName != nil && *address.NodeName != "" {
+				d.tagger.addNode(tags, *address.NodeName)
+				metadata[metaLabelPrefix+"endpoint_node_name"] = *address.NodeName
+			}
+			target := scrape.Target{
+				Name:           fmt.Sprintf("%s/%s:%v", key, id, port),
+				URL:            fmt.Sprintf("%s://%s:%v%s", config.Scheme, address.IP, port, config.Path),
+				Interval:       config.Interval,
+				Timeout:        config.Timeout,
+				MetricsInclude: config.MetricsInclude,
+				MetricsExclude: config.MetricsExclude,
+				Tags:           tags,
+				Metadata:       metadata,
+			}
+			if d.addressing.strategy == AddressingProxy {
+				if pod == nil {
+					log.Warningf("Skip address %v of service %v not backed by a pod", address.IP, key)
+					continue
+				}
+				target.URL = proxyURL(d.addressing.apiServerURL, "namespaces/"+podNamespace(pod, service.Namespace)+"/pods",
+					pod.Name, config.Scheme, port, config.Path)
+				target.Proxied = true
+			}
+			targets = append(targets, target)
+		}
+	}
+	return targets
+}
+
+// resolveEndpointPort returns endpoint port number metrics are exposed at,
+// the port is either a number, a port name or empty for the first service port
+func resolveEndpointPort(service *v1.Service, subset v1.EndpointSubset, port string) (int32, error) {
+	if number, err := strconv.Atoi(port); err == nil {
+		return int32(number), nil
+	}
+	if port == "" {
+		if len(service.Spec.Ports) == 0 {
+			return 0, trace.NotFound("service %v has no ports", service.Name)
+		}
+		port = service.Spec.Ports[0].Name
+	}
+	for _, p := range subset.Ports {
+		if p.Name == port {
+			return p.Port, nil
+		}
+	}
+	return 0, trace.NotFound("endpoints of service %v have no port %q", service.Name, port)
+}
@@ -0,0 +1,98 @@
+package kubernetes
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/gravitational/mm/pkg/constants"
+	"github.com/gravitational/mm/pkg/scrape"
+	v1 "k8s.io/client-go/1.4/pkg/api/v1"
+	"k8s.io/client-go/1.4/pkg/runtime"
+)
+
+// fakeTargets records discovered targets by name
+type fakeTargets struct {
+	sync.Mutex
+	targets map[string]scrape.Target
+}
+
+func newFakeTargets() *fakeTargets {
+	return &fakeTargets{targets: make(map[string]scrape.Target)}
+}
+
+func (f *fakeTargets) Update(target scrape.Target) {
+	f.Lock()
+	defer f.Unlock()
+	f.targets[target.Name] = target
+}
+
+func (f *fakeTargets) Remove(name string) {
+	f.Lock()
+	defer f.Unlock()
+	delete(f.targets, name)
+}
+
+func (f *fakeTargets) Sync(targets []scrape.Target) {
+	f.Lock()
+	defer f.Unlock()
+	f.targets = make(map[string]scrape.Target, len(targets))
+	for _, target := range targets {
+		f.targets[target.Name] = target
+	}
+}
+
+func TestEndpointsTargetsHavePodTags(t *testing.T) {
+	config := MetadataConfig{Labels: []string{"app"}}
+	if err := config.CheckAndSetDefaults(); err != nil {
+		t.Fatal(err)
+	}
+	targets := newFakeTargets()
+	discovery := newEndpointsDiscovery(newRegistry(targets), addressing{strategy: AddressingDirect}, newTagger(config))
+
+	controller := true
+	pod := &v1.Pod{ObjectMeta: v1.ObjectMeta{
+		Namespace: "default",
+		Name:      "web-5d8f9c-x2k4q",
+		Labels:    map[string]string{"app": "web", labelPodTemplateHash: "5d8f9c"},
+		OwnerReferences: []v1.OwnerReference{
+			{Kind: "ReplicaSet", Name: "web-5d8f9c", Controller: &controller},
+		},
+	}}
+	service := &v1.Service{
+		ObjectMeta: v1.ObjectMeta{Namespace: "default", Name: "web"},
+		Spec:       v1.ServiceSpec{Ports: []v1.ServicePort{{Name: "metrics", Port: 9100}}},
+	}
+	endpoints := &v1.Endpoints{
+		ObjectMeta: v1.ObjectMeta{Namespace: "default", Name: "web"},
+		Subsets: []v1.EndpointSubset{{
+			Addresses: []v1.EndpointAddress{{
+				IP:        "10.0.0.1",
+				TargetRef: &v1.ObjectReference{Kind: "Pod", Name: pod.Name},
+			}},
+			Ports: []v1.EndpointPort{{Name: "metrics", Port: 9100}},
+		}},
+	}
+	discovery.serviceHandler().OnUpdate(service)
+	discovery.endpointsHandler().OnUpdate(endpoints)
+	// the pod is informed after its endpoints and its target is refreshed
+	discovery.podHandler().OnSync([]runtime.Object{pod})
+
+	target, ok := targets.targets["default/web/"+pod.Name+":9100"]
+	if !ok {
+		t.Fatalf("expected target of the pod, got %v", targets.targets)
+	}
+	expected := map[string]string{
+		constants.TagOwnerKind:           "Deployment",
+		constants.TagOwnerName:           "web",
+		constants.TagLabelPrefix + "app": "web",
+		constants.TagPod:                 pod.Name,
+	}
+	for tag, value := range expected {
+		if target.Tags[tag] != value {
+			t.Errorf("expected tag %v=%v, got tags %v", tag, value, target.Tags)
+		}
+	}
+	if kind := target.Metadata[metaLabelPrefix+"pod_controller_kind"]; kind != "Deployment" {
+		t.Errorf("expected pod controller metadata, got %v", target.Metadata)
+	}
+}
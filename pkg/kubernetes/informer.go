@@ -0,0 +1,246 @@
+package kubernetes
+
+import (
+	"context"
+	"expvar"
+	"net/http"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/gravitational/trace"
+
+	"github.com/gravitational/mm/pkg/util"
+	api "k8s.io/client-go/1.4/pkg/api"
+	"k8s.io/client-go/1.4/pkg/api/errors"
+	"k8s.io/client-go/1.4/pkg/api/meta"
+	"k8s.io/client-go/1.4/pkg/api/unversioned"
+	"k8s.io/client-go/1.4/pkg/runtime"
+	watch "k8s.io/client-go/1.4/pkg/watch"
+)
+
+// EventHandler receives notifications about changes of informed objects
+type EventHandler interface {
+	// OnUpdate is called when an object is added or modified
+	OnUpdate(obj runtime.Object)
+	// OnDelete is called when an object is deleted
+	OnDelete(obj runtime.Object)
+	// OnSync is called with the full list of objects after every relist,
+	// objects missing from the list should be considered deleted
+	OnSync(objs []runtime.Object)
+}
+
+// handlerFuncs adapts functions to EventHandler
+type handlerFuncs struct {
+	onUpdate func(obj runtime.Object)
+	onDelete func(obj runtime.Object)
+	onSync   func(objs []runtime.Object)
+}
+
+func (h handlerFuncs) OnUpdate(obj runtime.Object) {
+	h.onUpdate(obj)
+}
+
+func (h handlerFuncs) OnDelete(obj runtime.Object) {
+	h.onDelete(obj)
+}
+
+func (h handlerFuncs) OnSync(objs []runtime.Object) {
+	h.onSync(objs)
+}
+
+const (
+	// watchBackoffInitial is a delay before reconnecting a failed watch
+	watchBackoffInitial = time.Second
+	// watchBackoffMax caps the delay between reconnects of a failing watch
+	watchBackoffMax = time.Minute
+)
+
+// watchReconnects counts re-established watches per kind of objects
+var watchReconnects = expvar.NewMap("kubernetes_watch_reconnects")
+
+// errWatchClosed is returned when API server closes the watch channel
+var errWatchClosed = trace.ConnectionProblem(nil, "watch channel closed")
+
+// listWatch lists and watches objects of a single kind
+type listWatch struct {
+	list  func(options api.ListOptions) (runtime.Object, error)
+	watch func(options api.ListOptions) (watch.Interface, error)
+}
+
+// informer lists objects, then watches them for changes and relists
+// them every resync period to repair any drift
+type informer struct {
+	listWatch
+	// kind is a kind of informed objects used for logging
+	kind     string
+	selector map[string]string
+	resync   time.Duration
+	handler  EventHandler
+	// reconnects is a number of times the watch has been re-established
+	reconnects int
+}
+
+// inform runs informer of objects of the given kind
+func inform(ctx context.Context, kind string, lw listWatch, labelsMap map[string]string,
+	resync time.Duration, handler EventHandler) error {
+	if resync <= 0 {
+		return trace.BadParameter("resync period should be positive, got %v", resync)
+	}
+	i := &informer{
+		listWatch: lw,
+		kind:      kind,
+		selector:  labelsMap,
+		resync:    resync,
+		handler:   handler,
+	}
+	return trace.Wrap(i.run(ctx))
+}
+
+// run lists and watches objects until the context is cancelled.
+// Closed or failed watches are re-established from the last seen resource
+// version, objects are relisted if that version is too old
+func (i *informer) run(ctx context.Context) error {
+	// fail fast if objects can't be listed at all
+	resourceVersion, err := i.relist()
+	if err != nil {
+		return trace.Wrap(err)
+	}
+
+	backoff := util.Backoff{Initial: watchBackoffInitial, Max: watchBackoffMax}
+	for {
+		if resourceVersion == "" {
+			resourceVersion, err = i.relist()
+			if err != nil {
+				log.Warningf("Failed to list %v: %v", i.kind, trace.UserMessage(err))
+				if !sleep(ctx, backoff.Next()) {
+					return nil
+				}
+				continue
+			}
+		}
+
+		started := time.Now()
+		lastVersion, err := i.watchFrom(ctx, resourceVersion)
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		var delay time.Duration
+		switch {
+		case err == nil:
+			// resync period has expired
+			resourceVersion = ""
+			backoff.Reset()
+			continue
+		case err == errWatchClosed && time.Since(started) >= watchBackoffMax:
+			// API server routinely times out long running watches
+			resourceVersion = lastVersion
+			backoff.Reset()
+		case isResourceExpired(err):
+			log.Infof("Resource version %v of %v is too old, relisting", lastVersion, i.kind)
+			resourceVersion = ""
+			delay = backoff.Next()
+		default:
+			log.Warningf("Watch of %v failed: %v", i.kind, trace.UserMessage(err))
+			resourceVersion = lastVersion
+			delay = backoff.Next()
+		}
+
+		watchReconnects.Add(i.kind, 1)
+		i.reconnects++
+		log.Infof("Reconnecting watch of %v in %v (reconnect #%v)", i.kind, delay, i.reconnects)
+		if !sleep(ctx, delay) {
+			return nil
+		}
+	}
+}
+
+// relist lists objects, passes them to the handler and returns
+// resource version of the list
+func (i *informer) relist() (string, error) {
+	list, err := i.list(api.ListOptions{LabelSelector: GetLabelSelector(i.selector)})
+	if err != nil {
+		return "", trace.Wrap(convertErr(err))
+	}
+	accessor, err := meta.ListAccessor(list)
+	if err != nil {
+		return "", trace.Wrap(err)
+	}
+	objs, err := meta.ExtractList(list)
+	if err != nil {
+		return "", trace.Wrap(err)
+	}
+	log.Debugf("Listed %v %v at resource version %v", len(objs), i.kind, accessor.GetResourceVersion())
+	i.handler.OnSync(objs)
+	return accessor.GetResourceVersion(), nil
+}
+
+// watchFrom watches objects starting from the resource version until
+// the resync period expires or the context is cancelled. It returns
+// the resource version of the last seen object
+func (i *informer) watchFrom(ctx context.Context, resourceVersion string) (string, error) {
+	watcher, err := i.watch(api.ListOptions{
+		LabelSelector:   GetLabelSelector(i.selector),
+		ResourceVersion: resourceVersion,
+	})
+	if err != nil {
+		return resourceVersion, trace.Wrap(convertErr(err))
+	}
+	defer watcher.Stop()
+
+	resync := time.NewTimer(i.resync)
+	defer resync.Stop()
+	for {
+		select {
+		case event, ok := <-watcher.ResultChan():
+			if !ok {
+				return resourceVersion, errWatchClosed
+			}
+			log.Debugf("Event: %s %s", event.Type, i.kind)
+			if event.Type == watch.Error {
+				// keep status error intact to check for expired resource version
+				return resourceVersion, errors.FromObject(event.Object)
+			}
+			if accessor, err := meta.Accessor(event.Object); err == nil {
+				resourceVersion = accessor.GetResourceVersion()
+			}
+			switch event.Type {
+			case watch.Added, watch.Modified:
+				i.handler.OnUpdate(event.Object)
+			case watch.Deleted:
+				i.handler.OnDelete(event.Object)
+			}
+		case <-resync.C:
+			return resourceVersion, nil
+		case <-ctx.Done():
+			return resourceVersion, nil
+		}
+	}
+}
+
+// isResourceExpired returns true if the error says that
+// the requested resource version is too old
+func isResourceExpired(err error) bool {
+	se, ok := trace.Unwrap(err).(*errors.StatusError)
+	if !ok {
+		return false
+	}
+	status := se.Status()
+	return status.Code == http.StatusGone || status.Reason == unversioned.StatusReasonExpired
+}
+
+// sleep waits for the delay and returns false if the context
+// has been cancelled in the meantime
+func sleep(ctx context.Context, delay time.Duration) bool {
+	if delay <= 0 {
+		return ctx.Err() == nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,207 @@
+package kubernetes
+
+import (
+	"strings"
+
+	"github.com/gravitational/trace"
+
+	"github.com/gravitational/mm/pkg/constants"
+	v1 "k8s.io/client-go/1.4/pkg/api/v1"
+)
+
+// Metadata of discovered objects added to points as tags
+const (
+	// MetadataNamespace is a namespace of the object
+	MetadataNamespace = "namespace"
+	// MetadataService is a name of the service
+	MetadataService = "service"
+	// MetadataPod is a name, IP address and container of the pod
+	MetadataPod = "pod"
+	// MetadataNode is a name of the node, and labels of the node
+	// for targets of nodes discovery role
+	MetadataNode = "node"
+	// MetadataOwner is a kind and name of the controller of the pod,
+	// e.g. Deployment, DaemonSet or StatefulSet
+	MetadataOwner = "owner"
+)
+
+// Metadata lists supported metadata
+var Metadata = []string{MetadataNamespace, MetadataService, MetadataPod, MetadataNode, MetadataOwner}
+
+// labelPodTemplateHash is a label of pods of a deployment replica set,
+// it is the suffix of the replica set name
+const labelPodTemplateHash = "pod-template-hash"
+
+// routingLabels map labels of objects selecting where their metrics
+// are written to tags of points
+var routingLabels = map[string]string{
+	constants.LabelMetricsDatabase:        constants.TagMetricsDatabase,
+	constants.LabelMetricsRetentionPolicy: constants.TagMetricsRetentionPolicy,
+}
+
+type MetadataConfig struct {
+	// Tags lists metadata added to points, all of them if empty
+	Tags []string
+	// Labels are names of service and pod labels added to points
+	Labels []string
+	// Annotations are names of service and pod annotations added to points
+	Annotations []string
+	// Routing adds tags selecting InfluxDB database and retention policy
+	// of points made of the routing labels of services and pods
+	Routing bool
+}
+
+func (c *MetadataConfig) CheckAndSetDefaults() error {
+	for _, tag := range c.Tags {
+		switch tag {
+		case MetadataNamespace, MetadataService, MetadataPod, MetadataNode, MetadataOwner:
+		default:
+			return trace.BadParameter("unsupported metadata %q, expected one of %v", tag, Metadata)
+		}
+	}
+	if len(c.Tags) == 0 {
+		c.Tags = Metadata
+	}
+	return nil
+}
+
+// tagger makes tags of points scraped from discovered objects
+type tagger struct {
+	MetadataConfig
+	enabled map[string]bool
+}
+
+func newTagger(config MetadataConfig) tagger {
+	enabled := make(map[string]bool, len(config.Tags))
+	for _, tag := range config.Tags {
+		enabled[tag] = true
+	}
+	return tagger{MetadataConfig: config, enabled: enabled}
+}
+
+// objectTags returns tags of points scraped from the object
+func (t tagger) objectTags(meta v1.ObjectMeta) map[string]string {
+	tags := make(map[string]string)
+	if t.enabled[MetadataNamespace] {
+		tags[constants.TagNamespace] = meta.Namespace
+	}
+	for label, tag := range routingLabels {
+		if value := meta.Labels[label]; t.Routing && value != "" {
+			tags[tag] = value
+		}
+	}
+	for _, name := range t.Labels {
+		if value, ok := meta.Labels[name]; ok {
+			tags[constants.TagLabelPrefix+sanitizeLabelName(name)] = value
+		}
+	}
+	for _, name := range t.Annotations {
+		if value, ok := meta.Annotations[name]; ok {
+			tags[constants.TagAnnotationPrefix+sanitizeLabelName(name)] = value
+		}
+	}
+	return tags
+}
+
+// serviceTags returns tags of points scraped from the service
+func (t tagger) serviceTags(service *v1.Service) map[string]string {
+	tags := t.objectTags(service.ObjectMeta)
+	if t.enabled[MetadataService] {
+		tags[constants.TagService] = service.Name
+	}
+	return tags
+}
+
+// podTags returns tags of points scraped from the container of the pod
+func (t tagger) podTags(pod *v1.Pod, container string) map[string]string {
+	tags := t.objectTags(pod.ObjectMeta)
+	t.addPod(tags, pod.Name, pod.Status.PodIP)
+	if t.enabled[MetadataPod] && container != "" {
+		tags[constants.TagContainer] = container
+	}
+	t.addNode(tags, pod.Spec.NodeName)
+	t.addOwner(tags, pod)
+	return tags
+}
+
+// addPodObject adds tags of the labels, annotations and the controller of
+// the pod scraped through a service, they take precedence over the service ones
+func (t tagger) addPodObject(tags map[string]string, pod *v1.Pod) {
+	for name, value := range t.objectTags(pod.ObjectMeta) {
+		tags[name] = value
+	}
+	t.addOwner(tags, pod)
+}
+
+// addOwner adds tags of the controller of the pod if it has one
+func (t tagger) addOwner(tags map[string]string, pod *v1.Pod) {
+	if kind, name := podOwner(pod); t.enabled[MetadataOwner] && kind != "" {
+		tags[constants.TagOwnerKind] = kind
+		tags[constants.TagOwnerName] = name
+	}
+}
+
+// addPod adds tags of the pod with the given name and IP address,
+// the name is unknown if empty
+func (t tagger) addPod(tags map[string]string, name, ip string) {
+	if !t.enabled[MetadataPod] {
+		return
+	}
+	if name != "" {
+		tags[constants.TagPod] = name
+	}
+	tags[constants.TagPodIP] = ip
+}
+
+// addNode adds tag of the node with the given name if it is known
+func (t tagger) addNode(tags map[string]string, name string) {
+	if t.enabled[MetadataNode] && name != "" {
+		tags[constants.TagNode] = name
+	}
+}
+
+// addNodeLabels adds tags of the node and its labels
+func (t tagger) addNodeLabels(tags map[string]string, node *v1.Node) {
+	if !t.enabled[MetadataNode] {
+		return
+	}
+	t.addNode(tags, node.Name)
+	for name, value := range node.Labels {
+		tags[constants.TagNodeLabelPrefix+sanitizeLabelName(name)] = value
+	}
+}
+
+// podOwner returns kind and name of the controller of the pod, pods of
+// replica sets created by deployments are attributed to the deployments
+func podOwner(pod *v1.Pod) (kind, name string) {
+	for _, ref := range pod.OwnerReferences {
+		if ref.Controller == nil || !*ref.Controller {
+			continue
+		}
+		hash := pod.Labels[labelPodTemplateHash]
+		if ref.Kind == "ReplicaSet" && hash != "" && strings.HasSuffix(ref.Name, "-"+hash) {
+			return "Deployment", strings.TrimSuffix(ref.Name, "-"+hash)
+		}
+		return ref.Kind, ref.Name
+	}
+	return "", ""
+}
+
+// metaLabelPrefix prefixes metadata labels of discovered objects,
+// they are named the same way as by Prometheus kubernetes discovery
+const metaLabelPrefix = "__meta_kubernetes_"
+
+// addObjectMetadata adds namespace, name, labels and annotations of the object
+// of the given kind, e.g. pod, to the metadata labels
+func addObjectMetadata(metadata map[string]string, kind string, meta v1.ObjectMeta) {
+	if meta.Namespace != "" {
+		metadata[metaLabelPrefix+"namespace"] = meta.Namespace
+	}
+	metadata[metaLabelPrefix+kind+"_name"] = meta.Name
+	for name, value := range meta.Labels {
+		metadata[metaLabelPrefix+kind+"_label_"+sanitizeLabelName(name)] = value
+	}
+	for name, value := range meta.Annotations {
+		metadata[metaLabelPrefix+kind+"_annotation_"+sanitizeLabelName(name)] = value
+	}
+}
@@ -0,0 +1,171 @@
+package kubernetes
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/gravitational/trace"
+
+	"github.com/gravitational/mm/pkg/constants"
+	"github.com/gravitational/mm/pkg/scrape"
+	v1 "k8s.io/client-go/1.4/pkg/api/v1"
+	"k8s.io/client-go/1.4/pkg/runtime"
+)
+
+// NodeTarget is a metrics endpoint exposed on every node
+type NodeTarget struct {
+	// Scheme is either http or https
+	Scheme string
+	// Port is a port number on the node
+	Port int
+	// Path is HTTP path metrics are exposed at
+	Path string
+}
+
+// ParseNodeTarget parses node target of form scheme://:port/path,
+// e.g. http://:9100/metrics or https://:10250/metrics/cadvisor
+func ParseNodeTarget(spec string) (*NodeTarget, error) {
+	u, err := url.Parse(spec)
+	if err != nil {
+		return nil, trace.BadParameter("invalid node target %q: %v", spec, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, trace.BadParameter("unsupported scheme of node target %q", spec)
+	}
+	_, portStr, err := net.SplitHostPort(u.Host)
+	if err != nil {
+		return nil, trace.BadParameter("invalid node target %q: %v", spec, err)
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port <= 0 {
+		return nil, trace.BadParameter("missing port of node target %q", spec)
+	}
+	path := u.Path
+	if path == "" {
+		path = constants.DefaultMetricsPath
+	}
+	return &NodeTarget{Scheme: u.Scheme, Port: port, Path: path}, nil
+}
+
+func (t NodeTarget) String() string {
+	return fmt.Sprintf("%s://:%v%s", t.Scheme, t.Port, t.Path)
+}
+
+// nodeDiscovery makes a target of every node target on every node
+type nodeDiscovery struct {
+	registry   *registry
+	addressing addressing
+	tagger     tagger
+	targets    []NodeTarget
+	// bearerToken authenticates requests to https node targets, e.g. kubelet
+	bearerToken string
+	// insecureSkipVerify disables verification of node certificates
+	insecureSkipVerify bool
+}
+
+func (d *nodeDiscovery) OnUpdate(obj runtime.Object) {
+	node, ok := obj.(*v1.Node)
+	if !ok {
+		log.Warningf("Unexpected object %T", obj)
+		return
+	}
+	d.registry.update(node.Name, d.makeTargets(node))
+}
+
+func (d *nodeDiscovery) OnDelete(obj runtime.Object) {
+	node, ok := obj.(*v1.Node)
+	if !ok {
+		log.Warningf("Unexpected object %T", obj)
+		return
+	}
+	d.registry.remove(node.Name)
+}
+
+func (d *nodeDiscovery) OnSync(objs []runtime.Object) {
+	targets := make(map[string][]scrape.Target, len(objs))
+	for _, obj := range objs {
+		node, ok := obj.(*v1.Node)
+		if !ok {
+			log.Warningf("Unexpected object %T", obj)
+			continue
+		}
+		targets[node.Name] = d.makeTargets(node)
+	}
+	d.registry.sync(targets)
+}
+
+// makeTargets returns scrape targets of the node
+func (d *nodeDiscovery) makeTargets(node *v1.Node) []scrape.Target {
+	address := nodeAddress(node)
+	if address == "" {
+		log.Warningf("Skip node %v without address", node.Name)
+		return nil
+	}
+
+	tags := make(map[string]string)
+	d.tagger.addNodeLabels(tags, node)
+
+	metadata := make(map[string]string)
+	addObjectMetadata(metadata, "node", node.ObjectMeta)
+
+	targets := make([]scrape.Target, 0, len(d.targets))
+	for _, t := range d.targets {
+		target := scrape.Target{
+			Name:     fmt.Sprintf("node/%s:%v%s", node.Name, t.Port, t.Path),
+			URL:      fmt.Sprintf("%s://%s:%v%s", t.Scheme, address, t.Port, t.Path),
+			Tags:     tags,
+			Metadata: metadata,
+		}
+		switch {
+		case d.addressing.strategy == AddressingProxy:
+			// API server authenticates itself to the node
+			target.URL = proxyURL(d.addressing.apiServerURL, "nodes", node.Name, t.Scheme, t.Port, t.Path)
+			target.Proxied = true
+		case t.Scheme == "https":
+			target.BearerToken = d.bearerToken
+			target.InsecureSkipVerify = d.insecureSkipVerify
+		}
+		targets = append(targets, target)
+	}
+	return targets
+}
+
+// nodeAddress returns internal IP address of the node
+// or external one if the node has no internal address
+func nodeAddress(node *v1.Node) string {
+	var external string
+	for _, address := range node.Status.Addresses {
+		switch address.Type {
+		case v1.NodeInternalIP:
+			return address.Address
+		case v1.NodeExternalIP:
+			external = address.Address
+		}
+	}
+	return external
+}
+
+// isNodeReady returns true if the node is ready
+func isNodeReady(node *v1.Node) bool {
+	for _, condition := range node.Status.Conditions {
+		if condition.Type == v1.NodeReady {
+			return condition.Status == v1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// sanitizeLabelName replaces characters of kubernetes label name
+// not allowed in prometheus label names with underscores
+func sanitizeLabelName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' {
+			return r
+		}
+		return '_'
+	}, name)
+}
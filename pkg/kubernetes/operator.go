@@ -1,6 +1,10 @@
 package kubernetes
 
 import (
+	"context"
+	"strconv"
+	"time"
+
 	"github.com/gravitational/trace"
 
 	"github.com/gravitational/mm/pkg/constants"
@@ -9,6 +13,7 @@ import (
 	"k8s.io/client-go/1.4/pkg/api/unversioned"
 	v1 "k8s.io/client-go/1.4/pkg/api/v1"
 	"k8s.io/client-go/1.4/pkg/labels"
+	"k8s.io/client-go/1.4/pkg/runtime"
 	serializer "k8s.io/client-go/1.4/pkg/runtime/serializer"
 	watch "k8s.io/client-go/1.4/pkg/watch"
 	"k8s.io/client-go/1.4/rest"
@@ -66,27 +71,56 @@ func (op *Operator) ListNodes(labelsMap map[string]string) (*v1.NodeList, error)
 	return nodes, nil
 }
 
+// InformNodes informs the handler about nodes matching the labels
+// the same way InformServices does
+func (op *Operator) InformNodes(ctx context.Context, labelsMap map[string]string,
+	resync time.Duration, handler EventHandler) error {
+	nodes := op.Client.Core().Nodes()
+	lw := listWatch{
+		list: func(options api.ListOptions) (runtime.Object, error) {
+			return nodes.List(options)
+		},
+		watch: nodes.Watch,
+	}
+	return trace.Wrap(inform(ctx, "nodes", lw, labelsMap, resync, handler))
+}
+
+// GetNodeIP returns internal IP address of a ready node
 func (op *Operator) GetNodeIP() (string, error) {
-	nodes, err := op.ListNodes(nil)
+	ips, err := op.GetReadyNodeIPs()
 	if err != nil {
 		return "", trace.Wrap(err)
 	}
+	return ips[0], nil
+}
+
+// GetReadyNodeIPs returns internal IP addresses of ready nodes,
+// it returns an error if there are none
+func (op *Operator) GetReadyNodeIPs() ([]string, error) {
+	nodes, err := op.ListNodes(nil)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
 	if len(nodes.Items) == 0 {
-		return "", trace.Errorf("no nodes were found")
+		return nil, trace.Errorf("no nodes were found")
 	}
-	node := &nodes.Items[0]
-
-	var nodeIP string
-	for _, address := range node.Status.Addresses {
-		if address.Type == v1.NodeInternalIP {
-			nodeIP = address.Address
-			break
+	var ips []string
+	for i := range nodes.Items {
+		node := &nodes.Items[i]
+		if !isNodeReady(node) {
+			continue
+		}
+		for _, address := range node.Status.Addresses {
+			if address.Type == v1.NodeInternalIP && address.Address != "" {
+				ips = append(ips, address.Address)
+				break
+			}
 		}
 	}
-	if nodeIP == "" {
-		return "", trace.Errorf("NodeInternalIP is empty for node %v", node.Name)
+	if len(ips) == 0 {
+		return nil, trace.Errorf("no ready nodes with NodeInternalIP were found")
 	}
-	return nodeIP, nil
+	return ips, nil
 }
 
 func (op *Operator) WatchServices(namespace string, labelsMap map[string]string) (watch.Interface, error) {
@@ -98,6 +132,59 @@ func (op *Operator) WatchServices(namespace string, labelsMap map[string]string)
 	return watcher, nil
 }
 
+// InformServices lists services matching the labels, passes them to the handler
+// and then watches them for changes, relisting them every resync period.
+// It blocks until the context is cancelled or if services can't be listed
+// initially, failed watches are reconnected
+func (op *Operator) InformServices(ctx context.Context, namespace string, labelsMap map[string]string,
+	resync time.Duration, handler EventHandler) error {
+	services := op.Client.Core().Services(constants.Namespace(namespace))
+	lw := listWatch{
+		list: func(options api.ListOptions) (runtime.Object, error) {
+			return services.List(options)
+		},
+		watch: services.Watch,
+	}
+	return trace.Wrap(inform(ctx, "services", lw, labelsMap, resync, handler))
+}
+
+// InformEndpoints informs the handler about endpoints matching the labels
+// the same way InformServices does
+func (op *Operator) InformEndpoints(ctx context.Context, namespace string, labelsMap map[string]string,
+	resync time.Duration, handler EventHandler) error {
+	endpoints := op.Client.Core().Endpoints(constants.Namespace(namespace))
+	lw := listWatch{
+		list: func(options api.ListOptions) (runtime.Object, error) {
+			return endpoints.List(options)
+		},
+		watch: endpoints.Watch,
+	}
+	return trace.Wrap(inform(ctx, "endpoints", lw, labelsMap, resync, handler))
+}
+
+func (op *Operator) WatchPods(namespace string, labelsMap map[string]string) (watch.Interface, error) {
+	watcher, err := op.Client.Core().Pods(constants.Namespace(namespace)).
+		Watch(api.ListOptions{LabelSelector: GetLabelSelector(labelsMap)})
+	if err != nil {
+		return nil, convertErr(err)
+	}
+	return watcher, nil
+}
+
+// InformPods informs the handler about pods matching the labels
+// the same way InformServices does
+func (op *Operator) InformPods(ctx context.Context, namespace string, labelsMap map[string]string,
+	resync time.Duration, handler EventHandler) error {
+	pods := op.Client.Core().Pods(constants.Namespace(namespace))
+	lw := listWatch{
+		list: func(options api.ListOptions) (runtime.Object, error) {
+			return pods.List(options)
+		},
+		watch: pods.Watch,
+	}
+	return trace.Wrap(inform(ctx, "pods", lw, labelsMap, resync, handler))
+}
+
 func (op *Operator) GetService(namespace string, name string) (*v1.Service, error) {
 	svc, err := op.Client.Core().Services(constants.Namespace(namespace)).Get(name)
 	if err != nil {
@@ -106,6 +193,24 @@ func (op *Operator) GetService(namespace string, name string) (*v1.Service, erro
 	return svc, nil
 }
 
+// GetServiceNodePort returns NodePort of the port of the service
+func (op *Operator) GetServiceNodePort(namespace string, name string, port int32) (int32, error) {
+	svc, err := op.GetService(namespace, name)
+	if err != nil {
+		return 0, trace.Wrap(err)
+	}
+	nodePort, err := ExtractServiceNodePort(svc, port)
+	return nodePort, trace.Wrap(err)
+}
+
+func (op *Operator) GetSecret(namespace string, name string) (*v1.Secret, error) {
+	secret, err := op.Client.Core().Secrets(constants.Namespace(namespace)).Get(name)
+	if err != nil {
+		return nil, convertErr(err)
+	}
+	return secret, nil
+}
+
 func (op *Operator) GetNode(name string) (*v1.Node, error) {
 	n, err := op.Client.Core().Nodes().Get(name)
 	if err != nil {
@@ -130,3 +235,20 @@ func ExtractServiceNodePort(svc *v1.Service, port int32) (int32, error) {
 	}
 	return 0, trace.Errorf("missing NodePort for port %v", port)
 }
+
+// ResolveServicePort returns service port with the given number or name,
+// or the first port of the service if the port is empty
+func ResolveServicePort(svc *v1.Service, port string) (*v1.ServicePort, error) {
+	if len(svc.Spec.Ports) == 0 {
+		return nil, trace.NotFound("service %v has no ports", svc.Name)
+	}
+	if port == "" {
+		return &svc.Spec.Ports[0], nil
+	}
+	for i, p := range svc.Spec.Ports {
+		if p.Name == port || strconv.Itoa(int(p.Port)) == port {
+			return &svc.Spec.Ports[i], nil
+		}
+	}
+	return nil, trace.NotFound("service %v has no port %v", svc.Name, port)
+}
@@ -0,0 +1,149 @@
+package kubernetes
+
+import (
+	"fmt"
+	"strconv"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/gravitational/trace"
+
+	"github.com/gravitational/mm/pkg/scrape"
+	v1 "k8s.io/client-go/1.4/pkg/api/v1"
+	"k8s.io/client-go/1.4/pkg/runtime"
+)
+
+// podDiscovery makes a target of every running and ready pod
+type podDiscovery struct {
+	registry   *registry
+	addressing addressing
+	tagger     tagger
+}
+
+func (d *podDiscovery) OnUpdate(obj runtime.Object) {
+	pod, ok := obj.(*v1.Pod)
+	if !ok {
+		log.Warningf("Unexpected object %T", obj)
+		return
+	}
+	d.registry.update(objectKey(pod.Namespace, pod.Name), d.makeTargets(pod))
+}
+
+func (d *podDiscovery) OnDelete(obj runtime.Object) {
+	pod, ok := obj.(*v1.Pod)
+	if !ok {
+		log.Warningf("Unexpected object %T", obj)
+		return
+	}
+	d.registry.remove(objectKey(pod.Namespace, pod.Name))
+}
+
+func (d *podDiscovery) OnSync(objs []runtime.Object) {
+	targets := make(map[string][]scrape.Target, len(objs))
+	for _, obj := range objs {
+		pod, ok := obj.(*v1.Pod)
+		if !ok {
+			log.Warningf("Unexpected object %T", obj)
+			continue
+		}
+		targets[objectKey(pod.Namespace, pod.Name)] = d.makeTargets(pod)
+	}
+	d.registry.sync(targets)
+}
+
+// makeTargets returns scrape targets of the pod, none if the pod
+// can't be scraped or is not running and ready
+func (d *podDiscovery) makeTargets(pod *v1.Pod) []scrape.Target {
+	key := objectKey(pod.Namespace, pod.Name)
+	if !isPodReady(pod) {
+		log.Debugf("Pod %v is not running or not ready", key)
+		return nil
+	}
+	config, err := GetScrapeConfig(pod.ObjectMeta)
+	if err != nil {
+		log.Warningf("Skip pod %v: %v", key, trace.UserMessage(err))
+		return nil
+	}
+	if !config.Scrape {
+		log.Debugf("Pod %v is excluded from scraping", key)
+		return nil
+	}
+	container, port, err := resolveContainerPort(pod, config.Port)
+	if err != nil {
+		log.Warningf("Skip pod %v: %v", key, trace.UserMessage(err))
+		return nil
+	}
+
+	tags := d.tagger.podTags(pod, container)
+	metadata := make(map[string]string)
+	addPodMetadata(metadata, pod)
+	metadata[metaLabelPrefix+"pod_ip"] = pod.Status.PodIP
+	if container != "" {
+		metadata[metaLabelPrefix+"pod_container_name"] = container
+	}
+	if pod.Spec.NodeName != "" {
+		metadata[metaLabelPrefix+"pod_node_name"] = pod.Spec.NodeName
+	}
+	target := scrape.Target{
+		Name:           fmt.Sprintf("%s:%v", key, port),
+		URL:            fmt.Sprintf("%s://%s:%v%s", config.Scheme, pod.Status.PodIP, port, config.Path),
+		Interval:       config.Interval,
+		Timeout:        config.Timeout,
+		MetricsInclude: config.MetricsInclude,
+		MetricsExclude: config.MetricsExclude,
+		Tags:           tags,
+		Metadata:       metadata,
+	}
+	if d.addressing.strategy == AddressingProxy {
+		target.URL = proxyURL(d.addressing.apiServerURL, "namespaces/"+pod.Namespace+"/pods",
+			pod.Name, config.Scheme, port, config.Path)
+		target.Proxied = true
+	}
+	return []scrape.Target{target}
+}
+
+// addPodMetadata adds metadata labels of the pod and its controller
+func addPodMetadata(metadata map[string]string, pod *v1.Pod) {
+	addObjectMetadata(metadata, "pod", pod.ObjectMeta)
+	if kind, name := podOwner(pod); kind != "" {
+		metadata[metaLabelPrefix+"pod_controller_kind"] = kind
+		metadata[metaLabelPrefix+"pod_controller_name"] = name
+	}
+}
+
+// isPodReady returns true if the pod is running and ready
+func isPodReady(pod *v1.Pod) bool {
+	if pod.Status.Phase != v1.PodRunning || pod.Status.PodIP == "" {
+		return false
+	}
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == v1.PodReady {
+			return condition.Status == v1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// resolveContainerPort returns container name and port number metrics are
+// exposed at, the port is either a number, a container port name or empty
+// for the first declared port
+func resolveContainerPort(pod *v1.Pod, port string) (string, int32, error) {
+	number, err := strconv.Atoi(port)
+	for _, container := range pod.Spec.Containers {
+		for _, p := range container.Ports {
+			switch {
+			case port == "",
+				err == nil && p.ContainerPort == int32(number),
+				err != nil && p.Name == port:
+				return container.Name, p.ContainerPort, nil
+			}
+		}
+	}
+	if err == nil {
+		// the port is not required to be declared
+		return "", int32(number), nil
+	}
+	if port == "" {
+		return "", 0, trace.NotFound("pod %v declares no ports", pod.Name)
+	}
+	return "", 0, trace.NotFound("pod %v has no port %q", pod.Name, port)
+}
@@ -0,0 +1,111 @@
+package kubernetes
+
+import (
+	"fmt"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/gravitational/trace"
+
+	"github.com/gravitational/mm/pkg/scrape"
+	v1 "k8s.io/client-go/1.4/pkg/api/v1"
+	"k8s.io/client-go/1.4/pkg/runtime"
+)
+
+// serviceDiscovery makes a single target of every service
+type serviceDiscovery struct {
+	registry   *registry
+	addressing addressing
+	tagger     tagger
+}
+
+func (d *serviceDiscovery) OnUpdate(obj runtime.Object) {
+	service, ok := obj.(*v1.Service)
+	if !ok {
+		log.Warningf("Unexpected object %T", obj)
+		return
+	}
+	d.registry.update(objectKey(service.Namespace, service.Name), d.makeTargets(service))
+}
+
+func (d *serviceDiscovery) OnDelete(obj runtime.Object) {
+	service, ok := obj.(*v1.Service)
+	if !ok {
+		log.Warningf("Unexpected object %T", obj)
+		return
+	}
+	d.registry.remove(objectKey(service.Namespace, service.Name))
+}
+
+func (d *serviceDiscovery) OnSync(objs []runtime.Object) {
+	// the node services are scraped through may have gone away since the previous sync
+	if err := d.addressing.resolveNodeIP(); err != nil {
+		log.Warningf("Failed to look up a ready node, keep scraping services through %v: %v",
+			d.addressing.nodeIP, trace.UserMessage(err))
+	}
+	targets := make(map[string][]scrape.Target, len(objs))
+	for _, obj := range objs {
+		service, ok := obj.(*v1.Service)
+		if !ok {
+			log.Warningf("Unexpected object %T", obj)
+			continue
+		}
+		targets[objectKey(service.Namespace, service.Name)] = d.makeTargets(service)
+	}
+	d.registry.sync(targets)
+}
+
+// makeTargets returns scrape targets of the service, none if
+// the service can't be scraped
+func (d *serviceDiscovery) makeTargets(service *v1.Service) []scrape.Target {
+	key := objectKey(service.Namespace, service.Name)
+	config, err := GetScrapeConfig(service.ObjectMeta)
+	if err != nil {
+		log.Warningf("Skip service %v: %v", key, trace.UserMessage(err))
+		return nil
+	}
+	if !config.Scrape {
+		log.Debugf("Service %v is excluded from scraping", key)
+		return nil
+	}
+	port, err := ResolveServicePort(service, config.Port)
+	if err != nil {
+		log.Warningf("Skip service %v: %v", key, trace.UserMessage(err))
+		return nil
+	}
+	target := scrape.Target{
+		Name:           key,
+		Interval:       config.Interval,
+		Timeout:        config.Timeout,
+		MetricsInclude: config.MetricsInclude,
+		MetricsExclude: config.MetricsExclude,
+		Tags:           d.tagger.serviceTags(service),
+		Metadata:       make(map[string]string),
+	}
+	addObjectMetadata(target.Metadata, "service", service.ObjectMeta)
+	switch d.addressing.strategy {
+	case AddressingNodePort:
+		if port.NodePort == 0 {
+			log.Warningf("Skip service %v: port %v has no NodePort", key, port.Port)
+			return nil
+		}
+		target.URL = fmt.Sprintf("%s://%s:%v%s", config.Scheme, d.addressing.nodeIP, port.NodePort, config.Path)
+	case AddressingClusterIP:
+		if service.Spec.ClusterIP == "" || service.Spec.ClusterIP == v1.ClusterIPNone {
+			log.Warningf("Skip service %v without ClusterIP", key)
+			return nil
+		}
+		target.URL = fmt.Sprintf("%s://%s:%v%s", config.Scheme, service.Spec.ClusterIP, port.Port, config.Path)
+	case AddressingDNS:
+		target.URL = fmt.Sprintf("%s://%s.%s.svc:%v%s", config.Scheme,
+			service.Name, service.Namespace, port.Port, config.Path)
+	case AddressingProxy:
+		var portID interface{} = port.Port
+		if port.Name != "" {
+			portID = port.Name
+		}
+		target.URL = proxyURL(d.addressing.apiServerURL, "namespaces/"+service.Namespace+"/services",
+			service.Name, config.Scheme, portID, config.Path)
+		target.Proxied = true
+	}
+	return []scrape.Target{target}
+}
@@ -0,0 +1,134 @@
+package opentsdb
+
+import (
+	"bytes"
+	"encoding/json"
+	"expvar"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gravitational/trace"
+	"github.com/influxdata/influxdb/client/v2"
+
+	"github.com/gravitational/mm/pkg/sink"
+)
+
+// sentValues counts values accepted by OpenTSDB
+var sentValues = expvar.NewInt("opentsdb_sent_values")
+
+type Config struct {
+	// URL is OpenTSDB HTTP API address, e.g. http://opentsdb.example.com:4242
+	URL string
+	// Timeout is a timeout of a single write
+	Timeout time.Duration
+}
+
+func (c *Config) CheckAndSetDefaults() error {
+	if c.URL == "" {
+		return trace.BadParameter("missing parameter URL")
+	}
+	if c.Timeout == 0 {
+		c.Timeout = sink.DefaultTimeout
+	}
+	return nil
+}
+
+// Client writes points to OpenTSDB /api/put endpoint, every numeric field
+// of a point is written as metric named after the point and the field
+type Client struct {
+	Config
+	putURL string
+	client *http.Client
+}
+
+func NewClient(config Config) (*Client, error) {
+	if err := config.CheckAndSetDefaults(); err != nil {
+		return nil, trace.Wrap(err)
+	}
+	u, err := url.Parse(config.URL)
+	if err != nil {
+		return nil, trace.BadParameter("invalid OpenTSDB URL %q: %v", config.URL, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, trace.BadParameter("unsupported scheme of OpenTSDB URL %q", config.URL)
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/api/put"
+	return &Client{
+		Config: config,
+		putURL: u.String(),
+		client: &http.Client{Timeout: config.Timeout},
+	}, nil
+}
+
+// dataPoint is a single value of OpenTSDB /api/put request
+type dataPoint struct {
+	Metric    string            `json:"metric"`
+	Timestamp int64             `json:"timestamp"`
+	Value     float64           `json:"value"`
+	Tags      map[string]string `json:"tags"`
+}
+
+func (c *Client) Send(points []*client.Point) error {
+	values := sink.Values(points)
+	dataPoints := make([]dataPoint, 0, len(values))
+	for _, value := range values {
+		tags := make(map[string]string, len(value.Tags))
+		for name, tag := range value.Tags {
+			if tag != "" {
+				tags[sanitize(name)] = sanitize(tag)
+			}
+		}
+		if len(tags) == 0 {
+			// OpenTSDB requires at least one tag
+			tags["field"] = sanitize(value.Field)
+		}
+		dataPoints = append(dataPoints, dataPoint{
+			Metric:    sanitize(value.Name + "." + value.Field),
+			Timestamp: value.Time.UnixNano() / int64(time.Millisecond),
+			Value:     value.Value,
+			Tags:      tags,
+		})
+	}
+	body, err := json.Marshal(dataPoints)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+
+	req, err := http.NewRequest("POST", c.putURL, bytes.NewReader(body))
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return trace.ConnectionProblem(err, "failed to write to OpenTSDB")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		message, _ := ioutil.ReadAll(resp.Body)
+		return trace.Wrap(&sink.HTTPError{
+			Backend:    "OpenTSDB",
+			StatusCode: resp.StatusCode,
+			Message:    strings.TrimSpace(string(message)),
+		})
+	}
+	sentValues.Add(int64(len(dataPoints)))
+	return nil
+}
+
+// sanitize replaces characters not allowed in OpenTSDB metric names and tags
+func sanitize(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		case r == '-', r == '_', r == '.', r == '/':
+			return r
+		}
+		return '_'
+	}, s)
+}
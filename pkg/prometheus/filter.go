@@ -0,0 +1,118 @@
+package prometheus
+
+import (
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/gravitational/trace"
+)
+
+// Filter selects metric families by their names
+type Filter struct {
+	// include matches names of kept families, all of them are kept if empty
+	include []pattern
+	// exclude matches names of dropped families
+	exclude []pattern
+}
+
+// pattern is either a glob or a regular expression matching metric names
+type pattern struct {
+	glob  string
+	regex *regexp.Regexp
+}
+
+// NewFilter returns filter keeping metric families matching any of include patterns
+// and none of exclude patterns, a pattern enclosed in slashes, e.g. /node_cpu_.+/,
+// is a regular expression matching the whole name, otherwise it is a glob, e.g. node_cpu_*
+func NewFilter(include, exclude []string) (*Filter, error) {
+	var filter Filter
+	for _, expr := range include {
+		p, err := newPattern(expr)
+		if err != nil {
+			return nil, trace.Wrap(err)
+		}
+		filter.include = append(filter.include, *p)
+	}
+	for _, expr := range exclude {
+		p, err := newPattern(expr)
+		if err != nil {
+			return nil, trace.Wrap(err)
+		}
+		filter.exclude = append(filter.exclude, *p)
+	}
+	return &filter, nil
+}
+
+// ParsePatterns splits comma separated patterns
+func ParsePatterns(value string) []string {
+	var patterns []string
+	for _, p := range strings.Split(value, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			patterns = append(patterns, p)
+		}
+	}
+	return patterns
+}
+
+func newPattern(expr string) (*pattern, error) {
+	if len(expr) > 1 && strings.HasPrefix(expr, "/") && strings.HasSuffix(expr, "/") {
+		re, err := regexp.Compile("^(?:" + expr[1:len(expr)-1] + ")$")
+		if err != nil {
+			return nil, trace.BadParameter("invalid metric name regex %q: %v", expr, err)
+		}
+		return &pattern{regex: re}, nil
+	}
+	if _, err := path.Match(expr, ""); err != nil {
+		return nil, trace.BadParameter("invalid metric name glob %q: %v", expr, err)
+	}
+	return &pattern{glob: expr}, nil
+}
+
+func (p pattern) match(name string) bool {
+	if p.regex != nil {
+		return p.regex.MatchString(name)
+	}
+	matched, _ := path.Match(p.glob, name)
+	return matched
+}
+
+// Match returns true if the family with the given name is kept,
+// nil filter keeps all families
+func (f *Filter) Match(name string) bool {
+	if f == nil {
+		return true
+	}
+	if len(f.include) > 0 && !matchAny(f.include, name) {
+		return false
+	}
+	return !matchAny(f.exclude, name)
+}
+
+func matchAny(patterns []pattern, name string) bool {
+	for _, p := range patterns {
+		if p.match(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// Matcher selects metric families by their names
+type Matcher interface {
+	// Match returns true if the family with the given name is kept
+	Match(name string) bool
+}
+
+// Filters keeps families matching all of the filters
+type Filters []*Filter
+
+// Match returns true if the family with the given name is kept by all filters
+func (f Filters) Match(name string) bool {
+	for _, filter := range f {
+		if !filter.Match(name) {
+			return false
+		}
+	}
+	return true
+}
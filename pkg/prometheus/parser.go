@@ -5,7 +5,6 @@ import (
 	"bytes"
 	"fmt"
 	"io"
-	"math"
 	"mime"
 	"net/http"
 	"time"
@@ -14,12 +13,64 @@ import (
 	"github.com/matttproud/golang_protobuf_extensions/pbutil"
 	dto "github.com/prometheus/client_model/go"
 	"github.com/prometheus/common/expfmt"
+
+	"github.com/gravitational/mm/pkg/relabel"
 )
 
+// exportedLabelPrefix prefixes labels of metrics conflicting with tags
+const exportedLabelPrefix = "exported_"
+
+// Options control conversion of scraped metrics
+type Options struct {
+	// Tags are added to labels of every metric
+	Tags map[string]string
+	// HonorLabels keeps labels of metrics conflicting with tags, otherwise
+	// the labels are renamed with exported_ prefix
+	HonorLabels bool
+	// RelabelConfigs are applied to labels of every metric with tags
+	// and the metric name in __name__ label
+	RelabelConfigs []relabel.Config
+	// Filters select converted metric families by their names
+	Filters Filters
+	// Schema defines how metrics are mapped to InfluxDB points
+	Schema Schema
+	// Transformer converts counters of a target to rates and deltas, optional
+	Transformer *Transformer
+}
+
 // Parse returns a slice of Metrics from a text representation of a
-// metrics
-func Parse(buf []byte, header http.Header) ([]*influx.Point, error) {
-	var points []*influx.Point
+// metrics, families not matching the filters are skipped
+func Parse(buf []byte, header http.Header, options Options) ([]*influx.Point, error) {
+	metricFamilies, err := ParseFamilies(buf, header, options.Matcher())
+	if err != nil {
+		return nil, err
+	}
+	return Points(metricFamilies, options)
+}
+
+// Matcher returns matcher of parsed metric families, it keeps the start time
+// of the scraped process the transformer detects restarts by even if the
+// filters skip it, such families are not converted
+func (o Options) Matcher() Matcher {
+	if o.Transformer == nil {
+		return o.Filters
+	}
+	return startTimeMatcher{Filters: o.Filters}
+}
+
+// startTimeMatcher keeps the start time of the process and families matching the filters
+type startTimeMatcher struct {
+	Filters
+}
+
+func (m startTimeMatcher) Match(name string) bool {
+	return name == metricStartTime || m.Filters.Match(name)
+}
+
+// ParseFamilies returns metric families matching the matcher from a text
+// or protobuf representation of metrics, lines of families not matching
+// it are removed from the text representation in place before it is parsed
+func ParseFamilies(buf []byte, header http.Header, matcher Matcher) (map[string]*dto.MetricFamily, error) {
 	var parser expfmt.TextParser
 	// parse even if the buffer begins with a newline
 	buf = bytes.TrimPrefix(buf, []byte("\n"))
@@ -42,61 +93,168 @@ func Parse(buf []byte, header http.Header) ([]*influx.Point, error) {
 				}
 				return nil, fmt.Errorf("reading metric family protocol buffer failed: %s", ierr)
 			}
-			metricFamilies[mf.GetName()] = mf
+			if matcher.Match(mf.GetName()) {
+				metricFamilies[mf.GetName()] = mf
+			}
 		}
 	} else {
+		reader = bufio.NewReader(bytes.NewReader(filterText(buf, matcher)))
 		metricFamilies, err = parser.TextToMetricFamilies(reader)
 		if err != nil {
 			return nil, fmt.Errorf("reading text format failed: %s", err)
 		}
+		for name := range metricFamilies {
+			if !matcher.Match(name) {
+				delete(metricFamilies, name)
+			}
+		}
+	}
+	return metricFamilies, nil
+}
+
+// filterText removes lines of metric families not matching the matcher from
+// the text representation reusing its buffer, it does not parse the metrics
+func filterText(buf []byte, matcher Matcher) []byte {
+	var (
+		// family and summary are name and type of the family of preceding lines
+		family  []byte
+		summary bool
+		// matched is the name of the last matched family and keep is the result
+		matched []byte
+		keep    = true
+	)
+	result := buf[:0]
+	for len(buf) > 0 {
+		line := buf
+		if i := bytes.IndexByte(buf, '\n'); i >= 0 {
+			line = buf[:i+1]
+		}
+		buf = buf[len(line):]
+
+		name, kind, isComment := parseTextLine(line)
+		switch {
+		case name == nil:
+			// blank lines and comments belong to no family
+		case isComment:
+			if !bytes.Equal(name, family) {
+				family, summary = append(family[:0], name...), false
+			}
+			if kind != nil {
+				summary = bytes.Equal(kind, []byte("summary")) || bytes.Equal(kind, []byte("histogram"))
+			}
+		case bytes.Equal(name, family):
+		case summary && isSummarySample(name, family):
+			name = family
+		default:
+			// a sample of an undeclared family
+			family, summary = append(family[:0], name...), false
+		}
+		if name != nil && !bytes.Equal(name, matched) {
+			matched, keep = append(matched[:0], name...), matcher.Match(string(name))
+		}
+		if name == nil || keep {
+			// the line is never moved forward so it does not overwrite unread lines
+			result = append(result, line...)
+		}
+	}
+	return result
+}
+
+// parseTextLine returns the metric name of the line of text format and the type
+// of TYPE comments, the name is nil if the line is blank or a plain comment
+func parseTextLine(line []byte) (name, kind []byte, isComment bool) {
+	line = bytes.TrimLeft(line, " \t")
+	if len(line) == 0 || line[0] != '#' {
+		end := bytes.IndexAny(line, "{ \t\r\n")
+		if end < 0 {
+			end = len(line)
+		}
+		if end == 0 {
+			return nil, nil, false
+		}
+		return line[:end], nil, false
 	}
+	keyword, rest := nextToken(line[1:])
+	if !bytes.Equal(keyword, []byte("HELP")) && !bytes.Equal(keyword, []byte("TYPE")) {
+		return nil, nil, true
+	}
+	name, rest = nextToken(rest)
+	if len(name) == 0 {
+		return nil, nil, true
+	}
+	if keyword[0] == 'T' {
+		kind, _ = nextToken(rest)
+	}
+	return name, kind, true
+}
+
+// nextToken returns the first token of the text separated by whitespace and the rest of the text
+func nextToken(text []byte) (token, rest []byte) {
+	text = bytes.TrimLeft(text, " \t")
+	end := bytes.IndexAny(text, " \t\r\n")
+	if end < 0 {
+		end = len(text)
+	}
+	return text[:end], text[end:]
+}
+
+// isSummarySample returns true if the name is a name of a sample of the
+// summary or histogram family, e.g. <family>_bucket
+func isSummarySample(name, family []byte) bool {
+	if !bytes.HasPrefix(name, family) {
+		return false
+	}
+	switch string(name[len(family):]) {
+	case "_sum", "_count", "_bucket":
+		return true
+	}
+	return false
+}
 
+// Points converts metric families to InfluxDB points mapped by the schema
+func Points(metricFamilies map[string]*dto.MetricFamily, options Options) ([]*influx.Point, error) {
+	var all []series
+	// metrics without timestamps share the time of conversion
+	now := time.Now()
+	transformer := options.Transformer
+	if transformer != nil {
+		transformer.begin(metricFamilies)
+		defer transformer.end()
+	}
 	// read metrics
 	for metricName, mf := range metricFamilies {
+		if !options.Filters.Match(metricName) {
+			continue
+		}
 		for _, m := range mf.Metric {
 			// reading tags
-			tags := makeLabels(m)
-			// reading fields
-			fields := make(map[string]interface{})
-			if mf.GetType() == dto.MetricType_SUMMARY {
-				// summary metric
-				fields = makeQuantiles(m)
-				fields["count"] = float64(m.GetSummary().GetSampleCount())
-				fields["sum"] = float64(m.GetSummary().GetSampleSum())
-			} else if mf.GetType() == dto.MetricType_HISTOGRAM {
-				// historgram metric
-				fields = makeBuckets(m)
-				fields["count"] = float64(m.GetHistogram().GetSampleCount())
-				fields["sum"] = float64(m.GetHistogram().GetSampleSum())
+			labels, name := options.labels(metricName, makeLabels(m))
+			if labels == nil {
+				continue
+			}
+			var t time.Time
+			if m.TimestampMs != nil && *m.TimestampMs > 0 {
+				t = time.Unix(0, *m.TimestampMs*1000000)
 			} else {
-				// standard metric
-				fields = getNameAndValue(m)
+				t = now
 			}
-			// converting to influx metric
-			if len(fields) > 0 {
-				var t time.Time
-				if m.TimestampMs != nil && *m.TimestampMs > 0 {
-					t = time.Unix(0, *m.TimestampMs*1000000)
-				} else {
-					t = time.Now()
-				}
-				pt, err := influx.NewPoint(metricName, tags, fields, t)
-				if err != nil {
-					return nil, fmt.Errorf("failed making point from metric: %s", err)
-				}
-				points = append(points, pt)
+			if mf.GetType() == dto.MetricType_COUNTER && transformer != nil && transformer.match(metricName) {
+				value := m.GetCounter().GetValue()
+				derived := transformer.derive(metricName, seriesKey(name, labels, time.Time{}), value, t)
+				all = options.Schema.appendCounter(all, name, value, derived, transformer.keepRaw, labels, t)
+				continue
 			}
+			all = options.Schema.appendSeries(all, name, mf.GetType(), m, labels, t)
 		}
 	}
-
-	return points, err
+	return options.Schema.points(all)
 }
 
 // Get Quantiles from summary metric
 func makeQuantiles(m *dto.Metric) map[string]interface{} {
 	fields := make(map[string]interface{})
 	for _, q := range m.GetSummary().Quantile {
-		if !math.IsNaN(q.GetValue()) {
+		if isFinite(q.GetValue()) {
 			fields[fmt.Sprint(q.GetQuantile())] = float64(q.GetValue())
 		}
 	}
@@ -121,19 +279,53 @@ func makeLabels(m *dto.Metric) map[string]string {
 	return result
 }
 
+// labels adds tags to the labels of the metric and relabels them,
+// it returns the labels without the metric name and the metric name
+// or nil labels if the metric is dropped
+func (o Options) labels(name string, labels map[string]string) (map[string]string, string) {
+	o.addTags(labels)
+	if len(o.RelabelConfigs) == 0 {
+		return labels, name
+	}
+	labels[LabelName] = name
+	labels = relabel.Process(labels, o.RelabelConfigs)
+	if labels == nil || labels[LabelName] == "" {
+		return nil, ""
+	}
+	name = labels[LabelName]
+	delete(labels, LabelName)
+	return labels, name
+}
+
+// addTags adds tags to the labels of a metric resolving conflicts
+// with its own labels
+func (o Options) addTags(labels map[string]string) {
+	for tag, value := range o.Tags {
+		existing, ok := labels[tag]
+		switch {
+		case !ok:
+		case o.HonorLabels:
+			continue
+		case existing != "":
+			labels[exportedLabelPrefix+tag] = existing
+		}
+		labels[tag] = value
+	}
+}
+
 // Get name and value from metric
 func getNameAndValue(m *dto.Metric) map[string]interface{} {
 	fields := make(map[string]interface{})
 	if m.Gauge != nil {
-		if !math.IsNaN(m.GetGauge().GetValue()) {
+		if isFinite(m.GetGauge().GetValue()) {
 			fields["gauge"] = float64(m.GetGauge().GetValue())
 		}
 	} else if m.Counter != nil {
-		if !math.IsNaN(m.GetGauge().GetValue()) {
+		if isFinite(m.GetCounter().GetValue()) {
 			fields["counter"] = float64(m.GetCounter().GetValue())
 		}
 	} else if m.Untyped != nil {
-		if !math.IsNaN(m.GetGauge().GetValue()) {
+		if isFinite(m.GetUntyped().GetValue()) {
 			fields["value"] = float64(m.GetUntyped().GetValue())
 		}
 	}
@@ -0,0 +1,108 @@
+package prometheus
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"testing"
+)
+
+const testMetrics = `# HELP go_goroutines Number of goroutines.
+# TYPE go_goroutines gauge
+go_goroutines 10
+# HELP rpc_duration_seconds RPC latency.
+# TYPE rpc_duration_seconds summary
+rpc_duration_seconds{quantile="0.5"} 0.1
+rpc_duration_seconds_sum 12
+rpc_duration_seconds_count 100
+# TYPE request_size_bytes histogram
+request_size_bytes_bucket{le="100"} 1
+request_size_bytes_bucket{le="+Inf"} 2
+request_size_bytes_sum 150
+request_size_bytes_count 2
+# TYPE requests_total counter
+requests_total{code="200"} 5
+requests_total_sum 1
+# comment of the next family
+  untyped_metric{a="b"} 1
+`
+
+func TestFilterText(t *testing.T) {
+	tests := []struct {
+		description string
+		include     []string
+		exclude     []string
+		// families are names of families left in the text
+		families []string
+	}{
+		{
+			description: "everything is kept without patterns",
+			families: []string{"go_goroutines", "request_size_bytes", "requests_total",
+				"requests_total_sum", "rpc_duration_seconds", "untyped_metric"},
+		},
+		{
+			description: "exclude glob removes HELP, TYPE and series of summaries",
+			exclude:     []string{"rpc_*"},
+			families: []string{"go_goroutines", "request_size_bytes", "requests_total",
+				"requests_total_sum", "untyped_metric"},
+		},
+		{
+			description: "exclude removes _bucket, _sum and _count series of histograms",
+			exclude:     []string{"request_size_bytes"},
+			families: []string{"go_goroutines", "requests_total", "requests_total_sum",
+				"rpc_duration_seconds", "untyped_metric"},
+		},
+		{
+			description: "suffixes belong to counters only as separate families",
+			exclude:     []string{"requests_total"},
+			families: []string{"go_goroutines", "request_size_bytes", "requests_total_sum",
+				"rpc_duration_seconds", "untyped_metric"},
+		},
+		{
+			description: "include regex keeps matching families only",
+			include:     []string{"/(go|rpc)_.+/"},
+			families:    []string{"go_goroutines", "rpc_duration_seconds"},
+		},
+		{
+			description: "include and exclude are combined",
+			include:     []string{"r*"},
+			exclude:     []string{"/.*_total(_sum)?/"},
+			families:    []string{"request_size_bytes", "rpc_duration_seconds"},
+		},
+	}
+	for _, test := range tests {
+		filter, err := NewFilter(test.include, test.exclude)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// parse the text without filters to check what filterText has left
+		text := filterText([]byte(testMetrics), Filters{filter})
+		families, err := ParseFamilies(text, http.Header{}, Filters(nil))
+		if err != nil {
+			t.Fatalf("%v: %v", test.description, err)
+		}
+		var names []string
+		for name := range families {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		if strings.Join(names, ",") != strings.Join(test.families, ",") {
+			t.Errorf("%v: expected families %v, got %v", test.description, test.families, names)
+		}
+	}
+}
+
+func TestFilterTextSkipsMalformedFilteredFamilies(t *testing.T) {
+	text := "# TYPE broken gauge\nbroken{a=\"b\" not a metric\ngo_goroutines 1\n"
+	filter, err := NewFilter(nil, []string{"broken"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	families, err := ParseFamilies([]byte(text), http.Header{}, Filters{filter})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(families) != 1 || families["go_goroutines"] == nil {
+		t.Errorf("expected go_goroutines only, got %v", families)
+	}
+}
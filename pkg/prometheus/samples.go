@@ -0,0 +1,94 @@
+package prometheus
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+
+	"github.com/gravitational/mm/pkg/relabel"
+)
+
+const (
+	// LabelName is a label holding metric name of a time series
+	LabelName = "__name__"
+	// labelQuantile is a label of quantiles of summaries
+	labelQuantile = "quantile"
+	// labelBucket is a label of upper bounds of histogram buckets
+	labelBucket = "le"
+)
+
+// Sample is a single value of a Prometheus time series
+type Sample struct {
+	// Labels identify the time series, including its metric name
+	Labels map[string]string
+	// Value is a value of the sample
+	Value float64
+	// Timestamp is a time of the sample in milliseconds since epoch
+	Timestamp int64
+}
+
+// Samples converts metric families to Prometheus samples the way Prometheus
+// itself stores them, labels of every sample are relabeled, now is a time
+// of samples without timestamps
+func Samples(metricFamilies map[string]*dto.MetricFamily, options Options, now time.Time) []Sample {
+	var samples []Sample
+	for metricName, mf := range metricFamilies {
+		if !options.Filters.Match(metricName) {
+			continue
+		}
+		for _, m := range mf.Metric {
+			timestamp := now.UnixNano() / int64(time.Millisecond)
+			if m.TimestampMs != nil && *m.TimestampMs > 0 {
+				timestamp = *m.TimestampMs
+			}
+			add := func(name string, value float64, extra ...string) {
+				labels := makeLabels(m)
+				options.addTags(labels)
+				for i := 0; i+1 < len(extra); i += 2 {
+					labels[extra[i]] = extra[i+1]
+				}
+				labels[LabelName] = name
+				labels = relabel.Process(labels, options.RelabelConfigs)
+				if labels == nil || labels[LabelName] == "" {
+					return
+				}
+				samples = append(samples, Sample{Labels: labels, Value: value, Timestamp: timestamp})
+			}
+
+			switch mf.GetType() {
+			case dto.MetricType_SUMMARY:
+				for _, q := range m.GetSummary().Quantile {
+					add(metricName, q.GetValue(), labelQuantile, fmt.Sprint(q.GetQuantile()))
+				}
+				add(metricName+"_sum", m.GetSummary().GetSampleSum())
+				add(metricName+"_count", float64(m.GetSummary().GetSampleCount()))
+			case dto.MetricType_HISTOGRAM:
+				infSeen := false
+				for _, b := range m.GetHistogram().Bucket {
+					if math.IsInf(b.GetUpperBound(), +1) {
+						infSeen = true
+					}
+					add(metricName+"_bucket", float64(b.GetCumulativeCount()),
+						labelBucket, fmt.Sprint(b.GetUpperBound()))
+				}
+				if !infSeen {
+					add(metricName+"_bucket", float64(m.GetHistogram().GetSampleCount()), labelBucket, "+Inf")
+				}
+				add(metricName+"_sum", m.GetHistogram().GetSampleSum())
+				add(metricName+"_count", float64(m.GetHistogram().GetSampleCount()))
+			default:
+				switch {
+				case m.Gauge != nil:
+					add(metricName, m.GetGauge().GetValue())
+				case m.Counter != nil:
+					add(metricName, m.GetCounter().GetValue())
+				case m.Untyped != nil:
+					add(metricName, m.GetUntyped().GetValue())
+				}
+			}
+		}
+	}
+	return samples
+}
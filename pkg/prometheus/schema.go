@@ -0,0 +1,270 @@
+package prometheus
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gravitational/trace"
+	influx "github.com/influxdata/influxdb/client/v2"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// Strategies of mapping metrics to InfluxDB points
+const (
+	// SchemaTyped writes a measurement per metric with a field named after
+	// the metric type, e.g. gauge, quantiles and buckets of summaries and
+	// histograms are written as fields named after their bounds
+	SchemaTyped = "typed"
+	// SchemaValue writes a measurement per time series the way Prometheus
+	// names them, e.g. <name>_bucket, with a single field value
+	SchemaValue = "value"
+	// SchemaShared writes all metrics with the same tags into a shared
+	// measurement with a field per time series name
+	SchemaShared = "shared"
+)
+
+// Schemas lists supported mapping strategies
+var Schemas = []string{SchemaTyped, SchemaValue, SchemaShared}
+
+// DefaultSharedMeasurement is a name of the shared measurement
+// of metrics without the measurement tag
+const DefaultSharedMeasurement = "prometheus"
+
+// fieldValue is a field of points with a single value
+const fieldValue = "value"
+
+// Schema defines how metrics are mapped to InfluxDB points
+type Schema struct {
+	// Strategy is a mapping strategy, typed by default
+	Strategy string
+	// BucketTags writes quantiles and histogram buckets of typed schema as
+	// separate points with quantile and le tags and value field instead of fields
+	BucketTags bool
+	// MeasurementPrefix prefixes names of measurements
+	MeasurementPrefix string
+	// MeasurementTag is a tag naming the shared measurement of metrics
+	// with the tag, e.g. service
+	MeasurementTag string
+}
+
+func (s *Schema) CheckAndSetDefaults() error {
+	switch s.Strategy {
+	case SchemaTyped, SchemaValue, SchemaShared:
+	case "":
+		s.Strategy = SchemaTyped
+	default:
+		return trace.BadParameter("unsupported schema %q, expected one of %v", s.Strategy, Schemas)
+	}
+	return nil
+}
+
+// series is a point made of a metric before the schema is applied
+type series struct {
+	measurement string
+	tags        map[string]string
+	fields      map[string]interface{}
+	time        time.Time
+}
+
+// appendSeries appends series made of the metric
+func (s Schema) appendSeries(all []series, name string, metricType dto.MetricType,
+	m *dto.Metric, labels map[string]string, t time.Time) []series {
+	if s.Strategy == SchemaValue || s.Strategy == SchemaShared {
+		return appendValues(all, name, metricType, m, labels, t)
+	}
+
+	var fields map[string]interface{}
+	switch metricType {
+	case dto.MetricType_SUMMARY:
+		if !s.BucketTags {
+			fields = makeQuantiles(m)
+		} else {
+			fields = make(map[string]interface{})
+			for _, q := range m.GetSummary().Quantile {
+				if isFinite(q.GetValue()) {
+					all = append(all, series{
+						measurement: name,
+						tags:        withTag(labels, labelQuantile, fmt.Sprint(q.GetQuantile())),
+						fields:      map[string]interface{}{fieldValue: q.GetValue()},
+						time:        t,
+					})
+				}
+			}
+		}
+		fields["count"] = float64(m.GetSummary().GetSampleCount())
+		fields["sum"] = float64(m.GetSummary().GetSampleSum())
+	case dto.MetricType_HISTOGRAM:
+		if !s.BucketTags {
+			fields = makeBuckets(m)
+		} else {
+			fields = make(map[string]interface{})
+			for _, b := range m.GetHistogram().Bucket {
+				all = append(all, series{
+					measurement: name,
+					tags:        withTag(labels, labelBucket, fmt.Sprint(b.GetUpperBound())),
+					fields:      map[string]interface{}{fieldValue: float64(b.GetCumulativeCount())},
+					time:        t,
+				})
+			}
+		}
+		fields["count"] = float64(m.GetHistogram().GetSampleCount())
+		fields["sum"] = float64(m.GetHistogram().GetSampleSum())
+	default:
+		fields = getNameAndValue(m)
+	}
+	if len(fields) > 0 {
+		all = append(all, series{measurement: name, tags: labels, fields: fields, time: t})
+	}
+	return all
+}
+
+// appendValues appends a series with a single value for every
+// time series of the metric the way Prometheus names them
+func appendValues(all []series, name string, metricType dto.MetricType,
+	m *dto.Metric, labels map[string]string, t time.Time) []series {
+	add := func(name string, value float64, tags map[string]string) {
+		if isFinite(value) {
+			all = append(all, series{
+				measurement: name,
+				tags:        tags,
+				fields:      map[string]interface{}{fieldValue: value},
+				time:        t,
+			})
+		}
+	}
+	switch metricType {
+	case dto.MetricType_SUMMARY:
+		for _, q := range m.GetSummary().Quantile {
+			add(name, q.GetValue(), withTag(labels, labelQuantile, fmt.Sprint(q.GetQuantile())))
+		}
+		add(name+"_sum", m.GetSummary().GetSampleSum(), labels)
+		add(name+"_count", float64(m.GetSummary().GetSampleCount()), labels)
+	case dto.MetricType_HISTOGRAM:
+		for _, b := range m.GetHistogram().Bucket {
+			add(name+"_bucket", float64(b.GetCumulativeCount()),
+				withTag(labels, labelBucket, fmt.Sprint(b.GetUpperBound())))
+		}
+		add(name+"_sum", m.GetHistogram().GetSampleSum(), labels)
+		add(name+"_count", float64(m.GetHistogram().GetSampleCount()), labels)
+	default:
+		switch {
+		case m.Gauge != nil:
+			add(name, m.GetGauge().GetValue(), labels)
+		case m.Counter != nil:
+			add(name, m.GetCounter().GetValue(), labels)
+		case m.Untyped != nil:
+			add(name, m.GetUntyped().GetValue(), labels)
+		}
+	}
+	return all
+}
+
+// appendCounter appends series of the counter and values derived from it,
+// the counter itself is omitted unless raw is true
+func (s Schema) appendCounter(all []series, name string, value float64, derived map[string]float64,
+	raw bool, labels map[string]string, t time.Time) []series {
+	if !isFinite(value) {
+		return all
+	}
+	if s.Strategy == SchemaValue || s.Strategy == SchemaShared {
+		add := func(name string, value float64) {
+			all = append(all, series{
+				measurement: name,
+				tags:        labels,
+				fields:      map[string]interface{}{fieldValue: value},
+				time:        t,
+			})
+		}
+		if raw {
+			add(name, value)
+		}
+		for field, derivedValue := range derived {
+			add(name+"_"+field, derivedValue)
+		}
+		return all
+	}
+
+	fields := make(map[string]interface{}, len(derived)+1)
+	if raw {
+		fields["counter"] = value
+	}
+	for field, derivedValue := range derived {
+		fields[field] = derivedValue
+	}
+	if len(fields) > 0 {
+		all = append(all, series{measurement: name, tags: labels, fields: fields, time: t})
+	}
+	return all
+}
+
+// points converts the series to points, series of the shared schema
+// with the same tags and time are merged into a single point
+func (s Schema) points(all []series) ([]*influx.Point, error) {
+	if s.Strategy == SchemaShared {
+		all = s.merge(all)
+	}
+	points := make([]*influx.Point, 0, len(all))
+	for _, series := range all {
+		pt, err := influx.NewPoint(s.MeasurementPrefix+series.measurement, series.tags, series.fields, series.time)
+		if err != nil {
+			return nil, fmt.Errorf("failed making point from metric: %s", err)
+		}
+		points = append(points, pt)
+	}
+	return points, nil
+}
+
+// merge turns measurements of the series into fields of shared measurements
+func (s Schema) merge(all []series) []series {
+	var merged []series
+	index := make(map[string]int)
+	for _, series := range all {
+		field, value := series.measurement, series.fields[fieldValue]
+		measurement := series.tags[s.MeasurementTag]
+		if s.MeasurementTag == "" || measurement == "" {
+			measurement = DefaultSharedMeasurement
+		}
+		key := seriesKey(measurement, series.tags, series.time)
+		i, ok := index[key]
+		if !ok {
+			i = len(merged)
+			index[key] = i
+			series.measurement, series.fields = measurement, make(map[string]interface{})
+			merged = append(merged, series)
+		}
+		merged[i].fields[field] = value
+	}
+	return merged
+}
+
+// seriesKey identifies a point by measurement, tags and time
+func seriesKey(measurement string, tags map[string]string, t time.Time) string {
+	names := make([]string, 0, len(tags))
+	for name := range tags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	parts := make([]string, 0, 2*len(names)+2)
+	parts = append(parts, measurement, fmt.Sprint(t.UnixNano()))
+	for _, name := range names {
+		parts = append(parts, name, tags[name])
+	}
+	return strings.Join(parts, "\x00")
+}
+
+// withTag returns a copy of the tags with the given tag set
+func withTag(tags map[string]string, name, value string) map[string]string {
+	result := make(map[string]string, len(tags)+1)
+	for tag, tagValue := range tags {
+		result[tag] = tagValue
+	}
+	result[name] = value
+	return result
+}
+
+func isFinite(value float64) bool {
+	return !math.IsNaN(value) && !math.IsInf(value, 0)
+}
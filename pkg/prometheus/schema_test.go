@@ -0,0 +1,116 @@
+package prometheus
+
+import (
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+const testSchemaMetrics = `# TYPE rpc_seconds summary
+rpc_seconds{quantile="0.5"} 0.1
+rpc_seconds{quantile="0.9"} NaN
+rpc_seconds_sum 2
+rpc_seconds_count 4
+# TYPE size_bytes histogram
+size_bytes_bucket{le="1"} 1
+size_bytes_bucket{le="+Inf"} 3
+size_bytes_sum 5
+size_bytes_count 3
+# TYPE up gauge
+up 1
+# TYPE temperature gauge
+temperature +Inf
+# TYPE errors_total counter
+errors_total NaN
+# TYPE untyped untyped
+untyped NaN
+`
+
+func TestSchemas(t *testing.T) {
+	tests := []struct {
+		schema Schema
+		points map[string]map[string]interface{}
+	}{
+		{
+			schema: Schema{Strategy: SchemaTyped},
+			points: map[string]map[string]interface{}{
+				"rpc_seconds": {"0.5": 0.1, "sum": 2.0, "count": 4.0},
+				"size_bytes":  {"1": 1.0, "+Inf": 3.0, "sum": 5.0, "count": 3.0},
+				"up":          {"gauge": 1.0},
+			},
+		},
+		{
+			schema: Schema{Strategy: SchemaTyped, BucketTags: true},
+			points: map[string]map[string]interface{}{
+				"rpc_seconds,quantile=0.5": {fieldValue: 0.1},
+				"rpc_seconds":              {"sum": 2.0, "count": 4.0},
+				"size_bytes,le=1":          {fieldValue: 1.0},
+				"size_bytes,le=+Inf":       {fieldValue: 3.0},
+				"size_bytes":               {"sum": 5.0, "count": 3.0},
+				"up":                       {"gauge": 1.0},
+			},
+		},
+		{
+			schema: Schema{Strategy: SchemaValue},
+			points: map[string]map[string]interface{}{
+				"rpc_seconds,quantile=0.5":  {fieldValue: 0.1},
+				"rpc_seconds_sum":           {fieldValue: 2.0},
+				"rpc_seconds_count":         {fieldValue: 4.0},
+				"size_bytes_bucket,le=1":    {fieldValue: 1.0},
+				"size_bytes_bucket,le=+Inf": {fieldValue: 3.0},
+				"size_bytes_sum":            {fieldValue: 5.0},
+				"size_bytes_count":          {fieldValue: 3.0},
+				"up":                        {fieldValue: 1.0},
+			},
+		},
+		{
+			schema: Schema{Strategy: SchemaShared},
+			points: map[string]map[string]interface{}{
+				"prometheus,quantile=0.5": {"rpc_seconds": 0.1},
+				"prometheus,le=1":         {"size_bytes_bucket": 1.0},
+				"prometheus,le=+Inf":      {"size_bytes_bucket": 3.0},
+				"prometheus": {
+					"rpc_seconds_sum": 2.0, "rpc_seconds_count": 4.0,
+					"size_bytes_sum": 5.0, "size_bytes_count": 3.0, "up": 1.0,
+				},
+			},
+		},
+	}
+	for _, test := range tests {
+		// bucket tags apply to typed schema only
+		for _, bucketTags := range []bool{false, true} {
+			if test.schema.Strategy == SchemaTyped && bucketTags != test.schema.BucketTags {
+				continue
+			}
+			schema := test.schema
+			schema.BucketTags = bucketTags
+			points, err := Parse([]byte(testSchemaMetrics), http.Header{}, Options{Schema: schema})
+			if err != nil {
+				t.Fatalf("%+v: %v", schema, err)
+			}
+			result := make(map[string]map[string]interface{})
+			for _, p := range points {
+				fields, err := p.Fields()
+				if err != nil {
+					t.Fatal(err)
+				}
+				result[pointKey(p.Name(), p.Tags())] = fields
+			}
+			if !reflect.DeepEqual(result, test.points) {
+				t.Errorf("%+v: expected points\n%v\ngot\n%v", schema, test.points, result)
+			}
+		}
+	}
+}
+
+// pointKey returns measurement and sorted tags of the point
+func pointKey(name string, tags map[string]string) string {
+	parts := []string{name}
+	for tag, value := range tags {
+		parts = append(parts, tag+"="+value)
+	}
+	sort.Strings(parts[1:])
+	return strings.Join(parts, ",")
+}
@@ -0,0 +1,144 @@
+package prometheus
+
+import (
+	"time"
+
+	"github.com/gravitational/trace"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// Fields and suffixes of values derived from counters
+const (
+	// DerivedRate is a per-second rate of a counter
+	DerivedRate = "rate"
+	// DerivedDelta is an increase of a counter since the previous scrape
+	DerivedDelta = "delta"
+)
+
+// metricStartTime is a metric of start time of the scraped process,
+// its change means the process has restarted and reset its counters
+const metricStartTime = "process_start_time_seconds"
+
+type TransformConfig struct {
+	// Rate are patterns of names of counter families converted to per-second rates
+	Rate []string
+	// Delta are patterns of names of counter families converted to increases
+	// since the previous scrape
+	Delta []string
+	// KeepRaw writes values of converted counters as well
+	KeepRaw bool
+}
+
+// Transform converts counters to rates and deltas
+type Transform struct {
+	// rate and delta select converted families, nil if none of them are
+	rate  *Filter
+	delta *Filter
+	// keepRaw writes values of converted counters as well
+	keepRaw bool
+}
+
+func NewTransform(config TransformConfig) (*Transform, error) {
+	transform := &Transform{keepRaw: config.KeepRaw}
+	var err error
+	if len(config.Rate) > 0 {
+		if transform.rate, err = NewFilter(config.Rate, nil); err != nil {
+			return nil, trace.Wrap(err)
+		}
+	}
+	if len(config.Delta) > 0 {
+		if transform.delta, err = NewFilter(config.Delta, nil); err != nil {
+			return nil, trace.Wrap(err)
+		}
+	}
+	return transform, nil
+}
+
+// NewTransformer returns transformer of counters of a single target,
+// it returns nil if the transform is nil
+func (t *Transform) NewTransformer() *Transformer {
+	if t == nil {
+		return nil
+	}
+	return &Transformer{
+		Transform: t,
+		previous:  make(map[string]counterSample),
+		current:   make(map[string]counterSample),
+	}
+}
+
+// counterSample is a value of a counter scraped at the time
+type counterSample struct {
+	value float64
+	time  time.Time
+}
+
+// Transformer keeps the previous values of counters of a target and converts
+// counters to values derived from them, it is not safe for concurrent use
+type Transformer struct {
+	*Transform
+	// previous are values of counters of the previous scrape by series
+	previous map[string]counterSample
+	// current are values of counters of the current scrape by series
+	current map[string]counterSample
+	// startTime is the start time of the scraped process, 0 if unknown
+	startTime float64
+	// restarted is true if the scraped process has restarted since the previous scrape
+	restarted bool
+}
+
+// begin starts conversion of counters of the scraped families
+func (t *Transformer) begin(metricFamilies map[string]*dto.MetricFamily) {
+	t.restarted = false
+	mf, ok := metricFamilies[metricStartTime]
+	if !ok || len(mf.Metric) == 0 {
+		return
+	}
+	startTime := mf.Metric[0].GetGauge().GetValue()
+	if mf.GetType() == dto.MetricType_UNTYPED {
+		startTime = mf.Metric[0].GetUntyped().GetValue()
+	}
+	t.restarted = t.startTime != 0 && startTime != t.startTime
+	t.startTime = startTime
+}
+
+// end finishes conversion of counters of the scrape, counters missing
+// in the scrape are forgotten
+func (t *Transformer) end() {
+	t.previous, t.current = t.current, t.previous
+	for key := range t.current {
+		delete(t.current, key)
+	}
+}
+
+// match returns true if the family with the given name is converted
+func (t *Transformer) match(name string) bool {
+	return (t.rate != nil && t.rate.Match(name)) || (t.delta != nil && t.delta.Match(name))
+}
+
+// derive records the value of the counter of the family and returns values
+// derived from it, nothing is derived on the first scrape of the counter
+func (t *Transformer) derive(family, key string, value float64, now time.Time) map[string]float64 {
+	t.current[key] = counterSample{value: value, time: now}
+	previous, ok := t.previous[key]
+	if !ok {
+		return nil
+	}
+	elapsed := now.Sub(previous.time).Seconds()
+	if elapsed <= 0 {
+		return nil
+	}
+	delta := value - previous.value
+	if delta < 0 || t.restarted {
+		// the counter has been reset and started from zero since the previous scrape
+		delta = value
+	}
+	derived := make(map[string]float64, 2)
+	if t.rate != nil && t.rate.Match(family) {
+		derived[DerivedRate] = delta / elapsed
+	}
+	if t.delta != nil && t.delta.Match(family) {
+		derived[DerivedDelta] = delta
+	}
+	return derived
+}
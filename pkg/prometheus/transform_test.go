@@ -0,0 +1,103 @@
+package prometheus
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+// scrapeCounter converts the counter and the start time of the process
+// scraped at the time in seconds and returns fields of points by measurement
+func scrapeCounter(t *testing.T, options Options, value float64, startTime, seconds int) map[string]map[string]interface{} {
+	text := fmt.Sprintf("# TYPE requests_total counter\nrequests_total %v %v\n"+
+		"# TYPE process_start_time_seconds gauge\nprocess_start_time_seconds %v %v\n",
+		value, seconds*1000, startTime, seconds*1000)
+	points, err := Parse([]byte(text), http.Header{}, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := make(map[string]map[string]interface{})
+	for _, p := range points {
+		fields, err := p.Fields()
+		if err != nil {
+			t.Fatal(err)
+		}
+		result[p.Name()] = fields
+	}
+	return result
+}
+
+func TestTransformerDerivesRatesAndDeltas(t *testing.T) {
+	transform, err := NewTransform(TransformConfig{Rate: []string{"requests_*"}, Delta: []string{"requests_*"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	filter, err := NewFilter(nil, []string{"process_*"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	options := Options{Filters: Filters{filter}, Transformer: transform.NewTransformer()}
+
+	tests := []struct {
+		description string
+		value       float64
+		startTime   int
+		seconds     int
+		fields      map[string]interface{}
+	}{
+		{
+			description: "nothing is derived on the first scrape",
+			value:       10, startTime: 1, seconds: 10,
+		},
+		{
+			description: "increase since the previous scrape",
+			value:       15, startTime: 1, seconds: 20,
+			fields: map[string]interface{}{DerivedDelta: 5.0, DerivedRate: 0.5},
+		},
+		{
+			description: "decrease is a reset of the counter",
+			value:       3, startTime: 1, seconds: 30,
+			fields: map[string]interface{}{DerivedDelta: 3.0, DerivedRate: 0.3},
+		},
+		{
+			description: "change of the start time is a reset even if the counter has increased",
+			value:       20, startTime: 2, seconds: 40,
+			fields: map[string]interface{}{DerivedDelta: 20.0, DerivedRate: 2.0},
+		},
+		{
+			description: "the same start time is not a reset",
+			value:       30, startTime: 2, seconds: 50,
+			fields: map[string]interface{}{DerivedDelta: 10.0, DerivedRate: 1.0},
+		},
+	}
+	for _, test := range tests {
+		points := scrapeCounter(t, options, test.value, test.startTime, test.seconds)
+		if _, ok := points["process_start_time_seconds"]; ok {
+			t.Errorf("%v: expected filtered start time not to be written", test.description)
+		}
+		if fields := points["requests_total"]; !reflect.DeepEqual(fields, test.fields) {
+			t.Errorf("%v: expected fields %v, got %v", test.description, test.fields, fields)
+		}
+	}
+}
+
+func TestTransformerKeepsRawCounters(t *testing.T) {
+	transform, err := NewTransform(TransformConfig{Delta: []string{"requests_total"}, KeepRaw: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	options := Options{Schema: Schema{Strategy: SchemaValue}, Transformer: transform.NewTransformer()}
+
+	points := scrapeCounter(t, options, 10, 1, 10)
+	if fields := points["requests_total"]; !reflect.DeepEqual(fields, map[string]interface{}{fieldValue: 10.0}) {
+		t.Errorf("expected raw counter on the first scrape, got %v", fields)
+	}
+	points = scrapeCounter(t, options, 12, 1, 20)
+	if fields := points["requests_total_delta"]; !reflect.DeepEqual(fields, map[string]interface{}{fieldValue: 2.0}) {
+		t.Errorf("expected delta series, got %v", points)
+	}
+	if _, ok := points["process_start_time_seconds"]; !ok {
+		t.Errorf("expected start time to be written unless filtered, got %v", points)
+	}
+}
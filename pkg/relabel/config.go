@@ -0,0 +1,40 @@
+package relabel
+
+import (
+	"io/ioutil"
+
+	"github.com/gravitational/trace"
+	"gopkg.in/yaml.v2"
+)
+
+// File is a relabeling configuration file, its sections have
+// the same names as in Prometheus scrape config
+type File struct {
+	// RelabelConfigs are applied to discovered targets
+	RelabelConfigs []Config `yaml:"relabel_configs"`
+	// MetricRelabelConfigs are applied to labels of scraped metrics
+	MetricRelabelConfigs []Config `yaml:"metric_relabel_configs"`
+}
+
+// LoadFile reads relabeling configuration from the YAML file
+func LoadFile(path string) (*File, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, trace.ConvertSystemError(err)
+	}
+	var file File
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, trace.BadParameter("invalid relabel config %v: %v", path, err)
+	}
+	for i := range file.RelabelConfigs {
+		if err := file.RelabelConfigs[i].CheckAndSetDefaults(); err != nil {
+			return nil, trace.Wrap(err, "invalid relabel config %v", path)
+		}
+	}
+	for i := range file.MetricRelabelConfigs {
+		if err := file.MetricRelabelConfigs[i].CheckAndSetDefaults(); err != nil {
+			return nil, trace.Wrap(err, "invalid metric relabel config %v", path)
+		}
+	}
+	return &file, nil
+}
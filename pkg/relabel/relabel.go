@@ -0,0 +1,228 @@
+package relabel
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gravitational/trace"
+	"github.com/prometheus/common/model"
+)
+
+// Action is an action performed by a relabeling step
+type Action string
+
+const (
+	// Replace sets target label to replacement if the regex matches
+	// concatenated source labels
+	Replace Action = "replace"
+	// Keep drops label sets not matching the regex
+	Keep Action = "keep"
+	// Drop drops label sets matching the regex
+	Drop Action = "drop"
+	// HashMod sets target label to the modulus of a hash of source labels
+	HashMod Action = "hashmod"
+	// LabelMap copies values of labels matching the regex to labels
+	// named after replacement
+	LabelMap Action = "labelmap"
+	// LabelDrop removes labels matching the regex
+	LabelDrop Action = "labeldrop"
+	// LabelKeep removes labels not matching the regex
+	LabelKeep Action = "labelkeep"
+)
+
+// Actions lists supported actions
+var Actions = []Action{Replace, Keep, Drop, HashMod, LabelMap, LabelDrop, LabelKeep}
+
+const (
+	// DefaultSeparator joins values of source labels
+	DefaultSeparator = ";"
+	// DefaultRegex matches any value
+	DefaultRegex = "(.*)"
+	// DefaultReplacement is the first match group
+	DefaultReplacement = "$1"
+)
+
+// Config is a single relabeling step, it has the same semantics
+// as relabel_config of Prometheus
+type Config struct {
+	// SourceLabels select values concatenated with Separator and matched against Regex
+	SourceLabels []string `yaml:"source_labels,flow"`
+	// Separator joins values of source labels, it defaults to ; in
+	// configuration files and may be empty
+	Separator string `yaml:"separator"`
+	// Regex is matched against the joined value, it is anchored on both ends
+	Regex Regexp `yaml:"regex"`
+	// Modulus of a hash of source labels for hashmod action
+	Modulus uint64 `yaml:"modulus"`
+	// TargetLabel is set to the result of replace and hashmod actions
+	TargetLabel string `yaml:"target_label"`
+	// Replacement may reference match groups of Regex, e.g. $1,
+	// it defaults to $1 in configuration files
+	Replacement string `yaml:"replacement"`
+	// Action is an action to perform, replace by default
+	Action Action `yaml:"action"`
+}
+
+func (c *Config) CheckAndSetDefaults() error {
+	if c.Regex.Regexp == nil {
+		c.Regex = MustNewRegexp(DefaultRegex)
+	}
+	if c.Action == "" {
+		c.Action = Replace
+	}
+	switch c.Action {
+	case Replace:
+		if c.TargetLabel == "" {
+			return trace.BadParameter("relabel action %v requires target_label", c.Action)
+		}
+	case HashMod:
+		if c.TargetLabel == "" || c.Modulus == 0 {
+			return trace.BadParameter("relabel action %v requires target_label and modulus", c.Action)
+		}
+	case LabelDrop, LabelKeep:
+		if len(c.SourceLabels) != 0 || c.TargetLabel != "" {
+			return trace.BadParameter("relabel action %v accepts only regex", c.Action)
+		}
+	case Keep, Drop, LabelMap:
+	default:
+		return trace.BadParameter("unsupported relabel action %q, expected one of %v", c.Action, Actions)
+	}
+	return nil
+}
+
+// UnmarshalYAML sets defaults of the fields missing in YAML
+func (c *Config) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain Config
+	*c = Config{Separator: DefaultSeparator, Replacement: DefaultReplacement, Action: Replace}
+	return trace.Wrap(unmarshal((*plain)(c)))
+}
+
+// Process applies relabeling steps to the labels in order, it returns
+// nil if the labels are dropped, the labels are not modified
+func Process(labels map[string]string, configs []Config) map[string]string {
+	if len(configs) == 0 {
+		return labels
+	}
+	result := make(map[string]string, len(labels))
+	for name, value := range labels {
+		result[name] = value
+	}
+	for _, config := range configs {
+		result = apply(result, config)
+		if result == nil {
+			return nil
+		}
+	}
+	return result
+}
+
+// apply performs a single relabeling step modifying the labels
+func apply(labels map[string]string, c Config) map[string]string {
+	values := make([]string, 0, len(c.SourceLabels))
+	for _, name := range c.SourceLabels {
+		values = append(values, labels[name])
+	}
+	value := strings.Join(values, c.Separator)
+
+	switch c.Action {
+	case Keep:
+		if !c.Regex.MatchString(value) {
+			return nil
+		}
+	case Drop:
+		if c.Regex.MatchString(value) {
+			return nil
+		}
+	case Replace:
+		indexes := c.Regex.FindStringSubmatchIndex(value)
+		if indexes == nil {
+			break
+		}
+		// Prometheus removes the configured target label if the expanded
+		// target label is invalid or the replacement is empty
+		target := string(c.Regex.ExpandString(nil, c.TargetLabel, value, indexes))
+		if !model.LabelName(target).IsValid() {
+			delete(labels, c.TargetLabel)
+			break
+		}
+		replacement := c.Regex.ExpandString(nil, c.Replacement, value, indexes)
+		if len(replacement) == 0 {
+			delete(labels, c.TargetLabel)
+			break
+		}
+		labels[target] = string(replacement)
+	case HashMod:
+		sum := md5.Sum([]byte(value))
+		labels[c.TargetLabel] = strconv.FormatUint(binary.BigEndian.Uint64(sum[8:])%c.Modulus, 10)
+	case LabelMap:
+		mapped := make(map[string]string)
+		for name, labelValue := range labels {
+			if c.Regex.MatchString(name) {
+				mapped[c.Regex.ReplaceAllString(name, c.Replacement)] = labelValue
+			}
+		}
+		for name, labelValue := range mapped {
+			labels[name] = labelValue
+		}
+	case LabelDrop:
+		for name := range labels {
+			if c.Regex.MatchString(name) {
+				delete(labels, name)
+			}
+		}
+	case LabelKeep:
+		for name := range labels {
+			if !c.Regex.MatchString(name) {
+				delete(labels, name)
+			}
+		}
+	}
+	return labels
+}
+
+// Regexp is a regular expression anchored on both ends
+type Regexp struct {
+	*regexp.Regexp
+	// expr is the original expression
+	expr string
+}
+
+// NewRegexp returns the expression anchored on both ends
+func NewRegexp(expr string) (Regexp, error) {
+	re, err := regexp.Compile("^(?:" + expr + ")$")
+	if err != nil {
+		return Regexp{}, trace.BadParameter("invalid regex %q: %v", expr, err)
+	}
+	return Regexp{Regexp: re, expr: expr}, nil
+}
+
+// MustNewRegexp returns the anchored expression and panics if it is invalid
+func MustNewRegexp(expr string) Regexp {
+	re, err := NewRegexp(expr)
+	if err != nil {
+		panic(err)
+	}
+	return re
+}
+
+// UnmarshalYAML parses the regular expression
+func (re *Regexp) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var expr string
+	if err := unmarshal(&expr); err != nil {
+		return trace.Wrap(err)
+	}
+	parsed, err := NewRegexp(expr)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	*re = parsed
+	return nil
+}
+
+// String returns the original expression
+func (re Regexp) String() string {
+	return re.expr
+}
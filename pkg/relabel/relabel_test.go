@@ -0,0 +1,210 @@
+package relabel
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestProcess(t *testing.T) {
+	input := map[string]string{"a": "foo", "b": "bar", "c": "baz"}
+	tests := []struct {
+		description string
+		configs     []Config
+		output      map[string]string
+	}{
+		{
+			description: "replace sets target label to expanded replacement",
+			configs: []Config{
+				{SourceLabels: []string{"a"}, Regex: MustNewRegexp("f(.*)"), TargetLabel: "d", Replacement: "ch${1}"},
+			},
+			output: map[string]string{"a": "foo", "b": "bar", "c": "baz", "d": "choo"},
+		},
+		{
+			description: "replace matches source labels joined with separator",
+			configs: []Config{
+				{
+					SourceLabels: []string{"a", "b"},
+					Separator:    ";",
+					Regex:        MustNewRegexp("f(.*);(.*)r"),
+					TargetLabel:  "a",
+					Replacement:  "b${1}${2}m",
+				},
+			},
+			output: map[string]string{"a": "boobam", "b": "bar", "c": "baz"},
+		},
+		{
+			description: "replace joins source labels with empty separator",
+			configs: []Config{
+				{SourceLabels: []string{"a", "b"}, Regex: MustNewRegexp("(.*)"), TargetLabel: "d", Replacement: "${1}"},
+			},
+			output: map[string]string{"a": "foo", "b": "bar", "c": "baz", "d": "foobar"},
+		},
+		{
+			description: "replace does nothing unless regex matches the whole value",
+			configs: []Config{
+				{SourceLabels: []string{"a"}, Regex: MustNewRegexp("o+"), TargetLabel: "d", Replacement: "x"},
+			},
+			output: input,
+		},
+		{
+			description: "replace removes target label if replacement is empty",
+			configs: []Config{
+				{SourceLabels: []string{"a"}, Regex: MustNewRegexp("(f)oo"), TargetLabel: "b", Replacement: "${2}"},
+			},
+			output: map[string]string{"a": "foo", "c": "baz"},
+		},
+		{
+			description: "replace expands target label",
+			configs: []Config{
+				{
+					SourceLabels: []string{"a"},
+					Regex:        MustNewRegexp("f(o)(o)"),
+					TargetLabel:  "label_${1}",
+					Replacement:  "${2}",
+				},
+			},
+			output: map[string]string{"a": "foo", "b": "bar", "c": "baz", "label_o": "o"},
+		},
+		{
+			description: "replace skips invalid target label",
+			configs: []Config{
+				{SourceLabels: []string{"a"}, Regex: MustNewRegexp("(.*)"), TargetLabel: "0${1}", Replacement: "x"},
+			},
+			output: input,
+		},
+		{
+			description: "missing source label has empty value",
+			configs: []Config{
+				{SourceLabels: []string{"missing"}, Regex: MustNewRegexp(""), TargetLabel: "d", Replacement: "empty"},
+			},
+			output: map[string]string{"a": "foo", "b": "bar", "c": "baz", "d": "empty"},
+		},
+		{
+			description: "keep keeps matching labels",
+			configs:     []Config{{SourceLabels: []string{"a"}, Regex: MustNewRegexp("f.*"), Action: Keep}},
+			output:      input,
+		},
+		{
+			description: "keep drops labels not matching the whole value",
+			configs:     []Config{{SourceLabels: []string{"a"}, Regex: MustNewRegexp("f"), Action: Keep}},
+			output:      nil,
+		},
+		{
+			description: "drop drops matching labels",
+			configs:     []Config{{SourceLabels: []string{"a", "b"}, Separator: ";", Regex: MustNewRegexp("foo;bar"), Action: Drop}},
+			output:      nil,
+		},
+		{
+			description: "drop keeps labels not matching",
+			configs:     []Config{{SourceLabels: []string{"a"}, Regex: MustNewRegexp("bar"), Action: Drop}},
+			output:      input,
+		},
+		{
+			description: "hashmod sets target label to modulus of md5 of source labels",
+			configs: []Config{
+				{SourceLabels: []string{"c"}, TargetLabel: "d", Modulus: 1000, Action: HashMod},
+			},
+			output: map[string]string{"a": "foo", "b": "bar", "c": "baz", "d": "976"},
+		},
+		{
+			description: "labelmap copies matching labels to expanded names",
+			configs: []Config{
+				{Regex: MustNewRegexp("(b.*)"), Replacement: "bar_${1}", Action: LabelMap},
+			},
+			output: map[string]string{"a": "foo", "b": "bar", "c": "baz", "bar_b": "bar"},
+		},
+		{
+			description: "labelmap maps labels of the original set only",
+			configs: []Config{
+				{Regex: MustNewRegexp("(.)"), Replacement: "x${1}", Action: LabelMap},
+			},
+			output: map[string]string{"a": "foo", "b": "bar", "c": "baz", "xa": "foo", "xb": "bar", "xc": "baz"},
+		},
+		{
+			description: "labeldrop removes matching labels",
+			configs:     []Config{{Regex: MustNewRegexp("b|c"), Action: LabelDrop}},
+			output:      map[string]string{"a": "foo"},
+		},
+		{
+			description: "labelkeep removes labels not matching",
+			configs:     []Config{{Regex: MustNewRegexp("a|b"), Action: LabelKeep}},
+			output:      map[string]string{"a": "foo", "b": "bar"},
+		},
+		{
+			description: "steps are applied in order",
+			configs: []Config{
+				{SourceLabels: []string{"a"}, Regex: MustNewRegexp("(.*)"), TargetLabel: "d", Replacement: "${1}"},
+				{Regex: MustNewRegexp("a"), Action: LabelDrop},
+				{SourceLabels: []string{"d"}, Regex: MustNewRegexp("foo"), Action: Keep},
+			},
+			output: map[string]string{"b": "bar", "c": "baz", "d": "foo"},
+		},
+	}
+	for _, test := range tests {
+		for i := range test.configs {
+			if err := test.configs[i].CheckAndSetDefaults(); err != nil {
+				t.Fatalf("%v: %v", test.description, err)
+			}
+		}
+		output := Process(input, test.configs)
+		if !reflect.DeepEqual(output, test.output) {
+			t.Errorf("%v: expected %v, got %v", test.description, test.output, output)
+		}
+	}
+	if expected := map[string]string{"a": "foo", "b": "bar", "c": "baz"}; !reflect.DeepEqual(input, expected) {
+		t.Errorf("expected input labels to be unchanged, got %v", input)
+	}
+}
+
+func TestLoadFileSetsDefaults(t *testing.T) {
+	f, err := ioutil.TempFile("", "relabel")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	_, err = f.WriteString(`
+relabel_configs:
+- source_labels: [__meta_kubernetes_pod_label_app]
+  target_label: app
+metric_relabel_configs:
+- source_labels: [__name__]
+  regex: go_.*
+  action: drop
+- source_labels: [job, instance]
+  separator: ""
+  target_label: key
+`)
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := LoadFile(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(file.RelabelConfigs) != 1 || len(file.MetricRelabelConfigs) != 2 {
+		t.Fatalf("expected configs of both sections, got %+v", file)
+	}
+	config := file.RelabelConfigs[0]
+	if config.Action != Replace || config.Replacement != DefaultReplacement ||
+		config.Separator != DefaultSeparator || config.Regex.String() != DefaultRegex {
+		t.Errorf("expected defaults to be set, got %+v", config)
+	}
+	output := Process(map[string]string{"__meta_kubernetes_pod_label_app": "web"}, file.RelabelConfigs)
+	if output["app"] != "web" {
+		t.Errorf("expected app label to be set, got %v", output)
+	}
+	if Process(map[string]string{"__name__": "go_goroutines"}, file.MetricRelabelConfigs) != nil {
+		t.Error("expected go_ metrics to be dropped")
+	}
+	if separator := file.MetricRelabelConfigs[1].Separator; separator != "" {
+		t.Errorf("expected explicit empty separator to be kept, got %q", separator)
+	}
+	output = Process(map[string]string{"job": "api", "instance": "a:80"}, file.MetricRelabelConfigs)
+	if output["key"] != "apia:80" {
+		t.Errorf("expected source labels joined without separator, got %v", output)
+	}
+}
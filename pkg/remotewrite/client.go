@@ -0,0 +1,119 @@
+package remotewrite
+
+import (
+	"bytes"
+	"crypto/tls"
+	"expvar"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/gravitational/trace"
+
+	"github.com/gravitational/mm/pkg/prometheus"
+	"github.com/gravitational/mm/pkg/sink"
+)
+
+// sentSamples counts samples accepted by remote write endpoint
+var sentSamples = expvar.NewInt("remote_write_sent_samples")
+
+type Config struct {
+	// URL is remote write endpoint, e.g. https://cortex.example.com/api/v1/push
+	URL string
+	// BearerToken authenticates writes, optional
+	BearerToken string
+	// Username and Password authenticate writes with basic auth, optional
+	Username string
+	Password string
+	// Headers are added to every request, e.g. X-Scope-OrgID of Cortex tenant
+	Headers map[string]string
+	// InsecureSkipVerify disables verification of the endpoint certificate
+	InsecureSkipVerify bool
+	// Timeout is a timeout of a single write
+	Timeout time.Duration
+}
+
+func (c *Config) CheckAndSetDefaults() error {
+	if c.URL == "" {
+		return trace.BadParameter("missing parameter URL")
+	}
+	u, err := url.Parse(c.URL)
+	if err != nil {
+		return trace.BadParameter("invalid remote write URL %q: %v", c.URL, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return trace.BadParameter("unsupported scheme of remote write URL %q", c.URL)
+	}
+	if c.Timeout == 0 {
+		c.Timeout = sink.DefaultTimeout
+	}
+	return nil
+}
+
+// Client writes samples to Prometheus remote write endpoint
+type Client struct {
+	Config
+	client *http.Client
+}
+
+func NewClient(config Config) (*Client, error) {
+	if err := config.CheckAndSetDefaults(); err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return &Client{
+		Config: config,
+		client: &http.Client{
+			Timeout: config.Timeout,
+			Transport: &http.Transport{
+				Proxy:           http.ProxyFromEnvironment,
+				TLSClientConfig: &tls.Config{InsecureSkipVerify: config.InsecureSkipVerify},
+			},
+		},
+	}, nil
+}
+
+// SendSamples posts the samples as a snappy compressed WriteRequest,
+// NaN and infinite values are written as they are
+func (c *Client) SendSamples(samples []prometheus.Sample) error {
+	data, err := marshalWriteRequest(samples)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	body := snappy.Encode(nil, data)
+	req, err := http.NewRequest("POST", c.URL, bytes.NewReader(body))
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	for name, value := range c.Headers {
+		req.Header.Set(name, value)
+	}
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	switch {
+	case c.BearerToken != "":
+		req.Header.Set("Authorization", "Bearer "+c.BearerToken)
+	case c.Username != "":
+		req.SetBasicAuth(c.Username, c.Password)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return trace.ConnectionProblem(err, "failed to write to remote write endpoint")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		message, _ := ioutil.ReadAll(resp.Body)
+		return trace.Wrap(&sink.HTTPError{
+			Backend:    "remote write endpoint",
+			StatusCode: resp.StatusCode,
+			Message:    strings.TrimSpace(string(message)),
+		})
+	}
+	sentSamples.Add(int64(len(samples)))
+	return nil
+}
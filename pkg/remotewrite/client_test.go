@@ -0,0 +1,134 @@
+package remotewrite
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/snappy"
+
+	"github.com/gravitational/mm/pkg/prometheus"
+	"github.com/gravitational/mm/pkg/remotewrite/prompb"
+)
+
+func TestSendWriteRequest(t *testing.T) {
+	var body []byte
+	var header http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header
+		compressed, err := ioutil.ReadAll(r.Body)
+		if err == nil {
+			body, err = snappy.Decode(nil, compressed)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+	}))
+	defer server.Close()
+
+	client, err := NewClient(Config{URL: server.URL, BearerToken: "token"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	samples := []prometheus.Sample{
+		{Labels: map[string]string{"job": "a", "__name__": "up"}, Value: 1, Timestamp: 2},
+		{Labels: map[string]string{"__name__": "requests_total", "code": "200"}, Value: 0.5, Timestamp: 1500000000000},
+	}
+	if err := client.SendSamples(samples); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, value := range map[string]string{
+		"Content-Type":     "application/x-protobuf",
+		"Content-Encoding": "snappy",
+		"Authorization":    "Bearer token",
+	} {
+		if header.Get(name) != value {
+			t.Errorf("expected header %v %q, got %q", name, value, header.Get(name))
+		}
+	}
+
+	// first time series encoded by hand as defined by the protocol
+	expected := []byte{
+		0x0a, 0x27, // timeseries
+		0x0a, 0x0e, // labels
+		0x0a, 0x08, '_', '_', 'n', 'a', 'm', 'e', '_', '_', 0x12, 0x02, 'u', 'p',
+		0x0a, 0x08, // labels
+		0x0a, 0x03, 'j', 'o', 'b', 0x12, 0x01, 'a',
+		0x12, 0x0b, // samples
+		0x09, 0, 0, 0, 0, 0, 0, 0xf0, 0x3f, 0x10, 0x02,
+	}
+	if !bytes.HasPrefix(body, expected) {
+		t.Errorf("expected request starting with\n%x\ngot\n%x", expected, body)
+	}
+
+	var request prompb.WriteRequest
+	if err := proto.Unmarshal(body, &request); err != nil {
+		t.Fatal(err)
+	}
+	expectedRequest := prompb.WriteRequest{Timeseries: []*prompb.TimeSeries{
+		{
+			Labels:  []*prompb.Label{{Name: "__name__", Value: "up"}, {Name: "job", Value: "a"}},
+			Samples: []*prompb.Sample{{Value: 1, Timestamp: 2}},
+		},
+		{
+			Labels:  []*prompb.Label{{Name: "__name__", Value: "requests_total"}, {Name: "code", Value: "200"}},
+			Samples: []*prompb.Sample{{Value: 0.5, Timestamp: 1500000000000}},
+		},
+	}}
+	if !reflect.DeepEqual(request, expectedRequest) {
+		t.Errorf("expected request %v, got %v", &expectedRequest, &request)
+	}
+}
+
+func TestSendNonFiniteSamples(t *testing.T) {
+	var request prompb.WriteRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		compressed, err := ioutil.ReadAll(r.Body)
+		var body []byte
+		if err == nil {
+			body, err = snappy.Decode(nil, compressed)
+		}
+		if err == nil {
+			err = proto.Unmarshal(body, &request)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+	}))
+	defer server.Close()
+
+	client, err := NewClient(Config{URL: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// staleness marker is a NaN with particular bits
+	staleNaN := math.Float64frombits(0x7ff0000000000002)
+	values := []float64{staleNaN, math.NaN(), math.Inf(+1), math.Inf(-1)}
+	var samples []prometheus.Sample
+	for i, value := range values {
+		samples = append(samples, prometheus.Sample{
+			Labels:    map[string]string{"__name__": "up", "i": fmt.Sprint(i)},
+			Value:     value,
+			Timestamp: int64(i),
+		})
+	}
+	if err := client.SendSamples(samples); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(request.Timeseries) != len(values) {
+		t.Fatalf("expected %v time series, got %v", len(values), &request)
+	}
+	for i, series := range request.Timeseries {
+		if len(series.Samples) != 1 || math.Float64bits(series.Samples[0].Value) != math.Float64bits(values[i]) {
+			t.Errorf("expected value %x of time series %v, got %v", math.Float64bits(values[i]), i, series)
+		}
+	}
+}
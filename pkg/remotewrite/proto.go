@@ -0,0 +1,42 @@
+package remotewrite
+
+import (
+	"sort"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/gravitational/trace"
+
+	"github.com/gravitational/mm/pkg/prometheus"
+	"github.com/gravitational/mm/pkg/remotewrite/prompb"
+)
+
+// newWriteRequest returns WriteRequest with a time series per sample,
+// labels of each series are sorted by name
+func newWriteRequest(samples []prometheus.Sample) *prompb.WriteRequest {
+	request := &prompb.WriteRequest{Timeseries: make([]*prompb.TimeSeries, 0, len(samples))}
+	for _, sample := range samples {
+		names := make([]string, 0, len(sample.Labels))
+		for name := range sample.Labels {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		series := &prompb.TimeSeries{
+			Labels:  make([]*prompb.Label, 0, len(names)),
+			Samples: []*prompb.Sample{{Value: sample.Value, Timestamp: sample.Timestamp}},
+		}
+		for _, name := range names {
+			series.Labels = append(series.Labels, &prompb.Label{Name: name, Value: sample.Labels[name]})
+		}
+		request.Timeseries = append(request.Timeseries, series)
+	}
+	return request
+}
+
+// marshalWriteRequest encodes samples as WriteRequest
+func marshalWriteRequest(samples []prometheus.Sample) ([]byte, error) {
+	data, err := proto.Marshal(newWriteRequest(samples))
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return data, nil
+}
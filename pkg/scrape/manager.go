@@ -0,0 +1,364 @@
+package scrape
+
+import (
+	"context"
+	"crypto/tls"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"reflect"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/gravitational/trace"
+
+	"github.com/gravitational/mm/pkg/prometheus"
+	"github.com/gravitational/mm/pkg/relabel"
+	"github.com/gravitational/mm/pkg/sink"
+)
+
+type Config struct {
+	// Interval is a default period between two scrapes of the same target
+	Interval time.Duration
+	// Timeout is a default timeout of a single scrape
+	Timeout time.Duration
+	// Sink receives scraped metrics as InfluxDB points, optional
+	Sink sink.Sink
+	// SampleSink receives scraped metrics as Prometheus samples, optional
+	SampleSink sink.SampleSink
+	// ProxyTransport authenticates requests of proxied targets to API server
+	ProxyTransport http.RoundTripper
+	// RelabelConfigs are applied to targets before they are scraped, optional
+	RelabelConfigs []relabel.Config
+	// MetricRelabelConfigs are applied to labels of scraped metrics, optional
+	MetricRelabelConfigs []relabel.Config
+	// Filter selects scraped metric families of all targets, optional
+	Filter *prometheus.Filter
+	// Schema defines how scraped metrics are mapped to points
+	Schema prometheus.Schema
+	// Transform converts counters to rates and deltas, optional
+	Transform *prometheus.Transform
+	// HonorLabels keeps labels of scraped metrics conflicting with tags of targets,
+	// otherwise the labels are renamed with exported_ prefix
+	HonorLabels bool
+}
+
+func (c *Config) CheckAndSetDefaults() error {
+	if c.Interval <= 0 {
+		return trace.BadParameter("scrape interval should be positive, got %v", c.Interval)
+	}
+	if c.Timeout <= 0 {
+		return trace.BadParameter("scrape timeout should be positive, got %v", c.Timeout)
+	}
+	if c.Sink == nil && c.SampleSink == nil {
+		return trace.BadParameter("missing parameter Sink or SampleSink")
+	}
+	if err := c.Schema.CheckAndSetDefaults(); err != nil {
+		return trace.Wrap(err)
+	}
+	return nil
+}
+
+// Manager keeps a set of active targets and scrapes each of them
+// periodically in a separate goroutine
+type Manager struct {
+	Config
+	sync.Mutex
+	ctx    context.Context
+	cancel context.CancelFunc
+	rand   *rand.Rand
+	client *http.Client
+	// insecureClient scrapes targets without verification of their certificates
+	insecureClient *http.Client
+	// proxyClient scrapes targets through API server proxy
+	proxyClient *http.Client
+	loops       map[string]*loop
+}
+
+// loop is a scrape loop of a single target
+type loop struct {
+	sync.Mutex
+	target Target
+	// filters select scraped metric families of the target
+	filters prometheus.Filters
+	// transformer converts counters of the target, nil if disabled
+	transformer *prometheus.Transformer
+	cancel      context.CancelFunc
+	// doneC is closed when the loop stops scraping
+	doneC  chan struct{}
+	health Health
+}
+
+// report records result of the scrape started at the given time
+func (l *loop) report(started time.Time, err error) {
+	l.Lock()
+	defer l.Unlock()
+	l.health.LastScrape = started
+	if err == nil {
+		if l.health.ConsecutiveFailures > 0 {
+			log.Infof("Scrape of %v has recovered after %v failures", l.target.Name, l.health.ConsecutiveFailures)
+		}
+		l.health.LastError = ""
+		l.health.ConsecutiveFailures = 0
+		return
+	}
+	l.health.LastError = trace.UserMessage(err)
+	l.health.ConsecutiveFailures++
+	log.Warningf("Failed to scrape %v at %v (%v failures in a row): %v",
+		l.target.Name, l.target.URL, l.health.ConsecutiveFailures, trace.UserMessage(err))
+	log.Debugf("Scrape error: %v", trace.DebugReport(err))
+}
+
+func (l *loop) getHealth() Health {
+	l.Lock()
+	defer l.Unlock()
+	return l.health
+}
+
+func NewManager(config Config) (*Manager, error) {
+	if err := config.CheckAndSetDefaults(); err != nil {
+		return nil, trace.Wrap(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	var proxyClient *http.Client
+	if config.ProxyTransport != nil {
+		proxyClient = &http.Client{Transport: config.ProxyTransport}
+	}
+	return &Manager{
+		Config: config,
+		ctx:    ctx,
+		cancel: cancel,
+		rand:   rand.New(rand.NewSource(time.Now().UnixNano())),
+		client: &http.Client{},
+		insecureClient: &http.Client{
+			Transport: &http.Transport{
+				Proxy:           http.ProxyFromEnvironment,
+				TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+			},
+		},
+		proxyClient: proxyClient,
+		loops:       make(map[string]*loop),
+	}, nil
+}
+
+// Health returns health of the scraped targets by their names
+func (m *Manager) Health() map[string]Health {
+	m.Lock()
+	defer m.Unlock()
+	health := make(map[string]Health, len(m.loops))
+	for name, l := range m.loops {
+		health[name] = l.getHealth()
+	}
+	return health
+}
+
+// Update starts scraping the target or restarts its scrape loop
+// if the target has changed since the last update
+func (m *Manager) Update(target Target) {
+	relabeled, err := target.Relabel(m.RelabelConfigs)
+	if err != nil {
+		log.Warningf("Skip target %v: %v", target.Name, trace.UserMessage(err))
+	} else if relabeled == nil {
+		log.Debugf("Target %v is dropped by relabeling", target.Name)
+	}
+
+	m.Lock()
+	defer m.Unlock()
+
+	if relabeled == nil {
+		m.remove(target.Name)
+		return
+	}
+	target = *relabeled
+	transformer := m.Transform.NewTransformer()
+	var previousC <-chan struct{}
+	if l, ok := m.loops[target.Name]; ok {
+		if reflect.DeepEqual(l.target, target) {
+			return
+		}
+		l.cancel()
+		// keep values of counters of the target, the transformer is used
+		// by the new loop once the previous one stops
+		transformer, previousC = l.transformer, l.doneC
+	}
+
+	log.Infof("Start scraping %v at %v", target.Name, target.URL)
+	// spread scrapes of different targets over the interval
+	offset := time.Duration(m.rand.Int63n(int64(m.interval(target))))
+	ctx, cancel := context.WithCancel(m.ctx)
+	l := &loop{
+		target:      target,
+		cancel:      cancel,
+		filters:     m.filters(target),
+		transformer: transformer,
+		doneC:       make(chan struct{}),
+	}
+	m.loops[target.Name] = l
+	go m.run(ctx, l, offset, previousC)
+}
+
+// Remove stops scraping the target with the given name
+func (m *Manager) Remove(name string) {
+	m.Lock()
+	defer m.Unlock()
+	m.remove(name)
+}
+
+// Sync makes the set of scraped targets match the given targets,
+// starting new ones and stopping the ones not in the list
+func (m *Manager) Sync(targets []Target) {
+	names := make(map[string]bool, len(targets))
+	for _, target := range targets {
+		names[target.Name] = true
+		m.Update(target)
+	}
+
+	m.Lock()
+	defer m.Unlock()
+	for name := range m.loops {
+		if !names[name] {
+			m.remove(name)
+		}
+	}
+}
+
+func (m *Manager) remove(name string) {
+	l, ok := m.loops[name]
+	if !ok {
+		return
+	}
+	log.Infof("Stop scraping %v", name)
+	l.cancel()
+	delete(m.loops, name)
+}
+
+// Stop stops all scrape loops
+func (m *Manager) Stop() {
+	m.Lock()
+	defer m.Unlock()
+	m.cancel()
+	m.loops = make(map[string]*loop)
+}
+
+// run scrapes the target until the context is cancelled, failed
+// scrapes are recorded in the target health and don't stop the loop,
+// scraping starts after previousC is closed unless it is nil
+func (m *Manager) run(ctx context.Context, l *loop, offset time.Duration, previousC <-chan struct{}) {
+	defer close(l.doneC)
+	if previousC != nil {
+		<-previousC
+	}
+	select {
+	case <-time.After(offset):
+	case <-ctx.Done():
+		return
+	}
+
+	ticker := time.NewTicker(m.interval(l.target))
+	defer ticker.Stop()
+	for {
+		started := time.Now()
+		l.report(started, m.scrape(ctx, l))
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (m *Manager) scrape(ctx context.Context, l *loop) error {
+	target := l.target
+	log.Debugf("Fetch metrics: %s", target.URL)
+
+	timeout := target.Timeout
+	if timeout == 0 {
+		timeout = m.Timeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	req, err := http.NewRequest("GET", target.URL, nil)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	if target.BearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+target.BearerToken)
+	}
+	client := m.client
+	switch {
+	case target.Proxied:
+		if m.proxyClient == nil {
+			return trace.BadParameter("missing API server proxy transport")
+		}
+		client = m.proxyClient
+	case target.InsecureSkipVerify:
+		client = m.insecureClient
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return trace.Errorf("%s returned HTTP status %s", target.URL, resp.Status)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return trace.Wrap(err, "error reading body")
+	}
+
+	options := prometheus.Options{
+		Tags:           target.Tags,
+		HonorLabels:    m.HonorLabels,
+		RelabelConfigs: m.MetricRelabelConfigs,
+		Filters:        l.filters,
+		Schema:         m.Schema,
+		Transformer:    l.transformer,
+	}
+	families, err := prometheus.ParseFamilies(body, resp.Header, options.Matcher())
+	if err != nil {
+		return trace.Wrap(err, "error reading metrics for %s", target.URL)
+	}
+	if m.Sink != nil {
+		points, err := prometheus.Points(families, options)
+		if err != nil {
+			return trace.Wrap(err, "error reading metrics for %s", target.URL)
+		}
+		if err := m.Sink.Write(ctx, points); err != nil {
+			return trace.Wrap(err, "error sending metrics")
+		}
+	}
+	if m.SampleSink != nil {
+		samples := prometheus.Samples(families, options, time.Now())
+		if err := m.SampleSink.WriteSamples(ctx, samples); err != nil {
+			return trace.Wrap(err, "error sending metrics")
+		}
+	}
+	return nil
+}
+
+// filters returns filters of metric families scraped from the target
+func (m *Manager) filters(target Target) prometheus.Filters {
+	filters := prometheus.Filters{m.Filter}
+	if len(target.MetricsInclude) == 0 && len(target.MetricsExclude) == 0 {
+		return filters
+	}
+	filter, err := prometheus.NewFilter(target.MetricsInclude, target.MetricsExclude)
+	if err != nil {
+		log.Warningf("Ignore metrics filter of %v: %v", target.Name, trace.UserMessage(err))
+		return filters
+	}
+	return append(filters, filter)
+}
+
+// interval returns scrape interval of the target
+func (m *Manager) interval(target Target) time.Duration {
+	if target.Interval != 0 {
+		return target.Interval
+	}
+	return m.Interval
+}
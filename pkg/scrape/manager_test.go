@@ -0,0 +1,100 @@
+package scrape
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/influxdata/influxdb/client/v2"
+
+	"github.com/gravitational/mm/pkg/prometheus"
+)
+
+// fakeSink records written points
+type fakeSink struct {
+	sync.Mutex
+	points []*client.Point
+}
+
+func (s *fakeSink) Write(ctx context.Context, points []*client.Point) error {
+	s.Lock()
+	defer s.Unlock()
+	s.points = append(s.points, points...)
+	return nil
+}
+
+func (s *fakeSink) Close() error {
+	return nil
+}
+
+// withoutField returns the number of written points and points without the field
+func (s *fakeSink) withoutField(field string) (total, without int) {
+	s.Lock()
+	defer s.Unlock()
+	for _, p := range s.points {
+		if fields, _ := p.Fields(); fields[field] == nil {
+			without++
+		}
+	}
+	return len(s.points), without
+}
+
+func TestUpdateKeepsCountersOfTarget(t *testing.T) {
+	var mu sync.Mutex
+	value := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		value += 10
+		fmt.Fprintf(w, "# TYPE requests_total counter\nrequests_total %v\n", value)
+	}))
+	defer server.Close()
+
+	transform, err := prometheus.NewTransform(prometheus.TransformConfig{
+		Delta:   []string{"requests_total"},
+		KeepRaw: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeSink{}
+	m, err := NewManager(Config{
+		Interval:  20 * time.Millisecond,
+		Timeout:   time.Second,
+		Sink:      s,
+		Transform: transform,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Stop()
+
+	target := Target{Name: "default/app", URL: server.URL}
+	m.Update(target)
+	waitForPoints(t, s, 1)
+
+	// the target changes, e.g. its annotations, and its loop is restarted
+	target.Timeout = 2 * time.Second
+	m.Update(target)
+	waitForPoints(t, s, 3)
+	if total, without := s.withoutField(prometheus.DerivedDelta); without != 1 {
+		t.Errorf("expected delta in every point but the first one, got %v of %v points without it", without, total)
+	}
+}
+
+func waitForPoints(t *testing.T, s *fakeSink, count int) {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if total, _ := s.withoutField(prometheus.DerivedDelta); total >= count {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting for %v points", count)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,99 @@
+package scrape
+
+import (
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gravitational/trace"
+
+	"github.com/gravitational/mm/pkg/relabel"
+)
+
+// Labels of targets available to relabeling besides tags and metadata
+const (
+	// LabelAddress is host and port of the target URL
+	LabelAddress = "__address__"
+	// LabelScheme is a scheme of the target URL
+	LabelScheme = "__scheme__"
+	// LabelMetricsPath is a path of the target URL
+	LabelMetricsPath = "__metrics_path__"
+	// reservedLabelPrefix prefixes labels removed after relabeling
+	reservedLabelPrefix = "__"
+)
+
+// Target is a single endpoint exposing metrics in prometheus format
+type Target struct {
+	// Name uniquely identifies the target, e.g. namespace/name of the service
+	Name string
+	// URL is the address metrics are fetched from
+	URL string
+	// Interval overrides the default scrape interval if set
+	Interval time.Duration
+	// Timeout overrides the default scrape timeout if set
+	Timeout time.Duration
+	// Tags are added to every point scraped from the target
+	Tags map[string]string
+	// Metadata are labels describing the discovered object available
+	// to relabeling, they are prefixed with __ and not added to points
+	Metadata map[string]string
+	// BearerToken authenticates scrape requests if set
+	BearerToken string
+	// InsecureSkipVerify disables verification of the target certificate
+	InsecureSkipVerify bool
+	// Proxied is true if the target is scraped through kubernetes API server proxy
+	Proxied bool
+	// MetricsInclude and MetricsExclude are patterns of names of scraped
+	// metric families in addition to the default filter
+	MetricsInclude []string
+	MetricsExclude []string
+}
+
+// Relabel applies relabeling to labels of the target made of its tags, metadata
+// and URL, the target with resulting tags and URL is returned or nil if it is dropped
+func (t Target) Relabel(configs []relabel.Config) (*Target, error) {
+	if len(configs) == 0 {
+		return &t, nil
+	}
+	u, err := url.Parse(t.URL)
+	if err != nil {
+		return nil, trace.BadParameter("invalid URL %q of target %v: %v", t.URL, t.Name, err)
+	}
+	labels := make(map[string]string, len(t.Tags)+len(t.Metadata)+3)
+	for name, value := range t.Metadata {
+		labels[name] = value
+	}
+	for name, value := range t.Tags {
+		labels[name] = value
+	}
+	labels[LabelAddress] = u.Host
+	labels[LabelScheme] = u.Scheme
+	labels[LabelMetricsPath] = u.Path
+
+	labels = relabel.Process(labels, configs)
+	if labels == nil {
+		return nil, nil
+	}
+	if labels[LabelAddress] == "" {
+		return nil, trace.BadParameter("target %v has no address after relabeling", t.Name)
+	}
+	u.Host, u.Scheme, u.Path = labels[LabelAddress], labels[LabelScheme], labels[LabelMetricsPath]
+	t.URL = u.String()
+	t.Tags = make(map[string]string, len(labels))
+	for name, value := range labels {
+		if !strings.HasPrefix(name, reservedLabelPrefix) {
+			t.Tags[name] = value
+		}
+	}
+	return &t, nil
+}
+
+// Health describes results of the recent scrapes of a target
+type Health struct {
+	// LastScrape is the time the last scrape has started at
+	LastScrape time.Time `json:"last_scrape"`
+	// LastError is the error of the last scrape if it has failed
+	LastError string `json:"last_error,omitempty"`
+	// ConsecutiveFailures is a number of failed scrapes in a row
+	ConsecutiveFailures int `json:"consecutive_failures"`
+}
@@ -0,0 +1,204 @@
+package sink
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/gravitational/trace"
+	"github.com/influxdata/influxdb/client/v2"
+
+	"github.com/gravitational/mm/pkg/prometheus"
+)
+
+// Drop policies define which points are dropped when the buffer is full
+const (
+	// DropOldest drops the oldest buffered points to make room for new ones
+	DropOldest = "oldest"
+	// DropNewest drops new points until the buffer is flushed
+	DropNewest = "newest"
+)
+
+// DropPolicies lists supported drop policies
+var DropPolicies = []string{DropOldest, DropNewest}
+
+type BatchConfig struct {
+	// Name identifies the sink in logs and counters
+	Name string
+	// Writer writes batches of points
+	Writer Writer
+	// SampleWriter writes batches of samples of sinks of samples instead of Writer
+	SampleWriter SampleWriter
+	// BatchSize is a maximum number of points in a single write,
+	// reaching it triggers a flush
+	BatchSize int
+	// FlushInterval is a maximum time points are buffered for
+	FlushInterval time.Duration
+	// MaxBufferedPoints bounds the number of buffered points
+	MaxBufferedPoints int
+	// DropPolicy defines which points are dropped when the buffer is full
+	DropPolicy string
+}
+
+func (c *BatchConfig) CheckAndSetDefaults() error {
+	if c.Name == "" {
+		return trace.BadParameter("missing parameter Name")
+	}
+	if err := checkWriters(c.Writer, c.SampleWriter); err != nil {
+		return trace.Wrap(err)
+	}
+	if c.BatchSize <= 0 {
+		return trace.BadParameter("batch size should be positive, got %v", c.BatchSize)
+	}
+	if c.FlushInterval <= 0 {
+		return trace.BadParameter("flush interval should be positive, got %v", c.FlushInterval)
+	}
+	if c.MaxBufferedPoints < c.BatchSize {
+		return trace.BadParameter("max buffered points %v should not be less than batch size %v",
+			c.MaxBufferedPoints, c.BatchSize)
+	}
+	switch c.DropPolicy {
+	case DropOldest, DropNewest:
+	case "":
+		c.DropPolicy = DropOldest
+	default:
+		return trace.BadParameter("unsupported drop policy %q", c.DropPolicy)
+	}
+	return nil
+}
+
+// BatchWriter is a sink accumulating points or samples sent by all targets
+// and writing them in batches in background
+type BatchWriter struct {
+	BatchConfig
+	sync.Mutex
+	buffer batch
+	// flushC triggers a flush when the buffer reaches the batch size
+	flushC    chan struct{}
+	closeC    chan struct{}
+	closeOnce sync.Once
+	doneC     chan struct{}
+}
+
+func NewBatchWriter(config BatchConfig) (*BatchWriter, error) {
+	if err := config.CheckAndSetDefaults(); err != nil {
+		return nil, trace.Wrap(err)
+	}
+	w := &BatchWriter{
+		BatchConfig: config,
+		flushC:      make(chan struct{}, 1),
+		closeC:      make(chan struct{}),
+		doneC:       make(chan struct{}),
+	}
+	w.buffer = w.sender().empty()
+	go w.run()
+	return w, nil
+}
+
+// Write buffers the points, it never blocks on writes and drops points
+// according to the drop policy if the buffer is full
+func (w *BatchWriter) Write(ctx context.Context, points []*client.Point) error {
+	return trace.Wrap(w.write(pointBatch(points)))
+}
+
+// WriteSamples buffers the samples the same way Write buffers points
+func (w *BatchWriter) WriteSamples(ctx context.Context, samples []prometheus.Sample) error {
+	return trace.Wrap(w.write(sampleBatch(samples)))
+}
+
+func (w *BatchWriter) write(items batch) error {
+	if err := w.sender().check(items); err != nil {
+		return trace.Wrap(err)
+	}
+	w.Lock()
+	defer w.Unlock()
+
+	dropped := 0
+	if w.DropPolicy == DropNewest {
+		if room := w.MaxBufferedPoints - w.buffer.len(); items.len() > room {
+			dropped = items.len() - room
+			items = items.slice(0, room)
+		}
+		w.buffer = w.buffer.append(items)
+	} else {
+		w.buffer = w.buffer.append(items)
+		if over := w.buffer.len() - w.MaxBufferedPoints; over > 0 {
+			dropped = over
+			// copy to release the dropped items
+			w.buffer = w.sender().empty().append(w.buffer.slice(over, w.buffer.len()))
+		}
+	}
+	if dropped > 0 {
+		droppedPoints.Add(w.Name, int64(dropped))
+		log.Warningf("Write buffer of %v sink is full, dropped %v %v %v",
+			w.Name, dropped, w.DropPolicy, w.buffer.unit())
+	}
+
+	if w.buffer.len() >= w.BatchSize {
+		select {
+		case w.flushC <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// Close flushes buffered points, stops the writer and closes the backend,
+// failed writes are not retried once it is called
+func (w *BatchWriter) Close() error {
+	var err error
+	w.closeOnce.Do(func() {
+		close(w.closeC)
+		w.sender().stop()
+		<-w.doneC
+		err = w.sender().close()
+	})
+	return trace.Wrap(err)
+}
+
+func (w *BatchWriter) sender() sender {
+	return sender{writer: w.Writer, sampleWriter: w.SampleWriter}
+}
+
+func (w *BatchWriter) run() {
+	defer close(w.doneC)
+	ticker := time.NewTicker(w.FlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-w.flushC:
+		case <-w.closeC:
+			w.flush()
+			return
+		}
+		w.flush()
+	}
+}
+
+// flush writes buffered points or samples split into batches
+func (w *BatchWriter) flush() {
+	w.Lock()
+	items := w.buffer
+	w.buffer = w.sender().empty()
+	w.Unlock()
+
+	for items.len() > 0 {
+		size := w.BatchSize
+		if size > items.len() {
+			size = items.len()
+		}
+		batch := items.slice(0, size)
+		items = items.slice(size, items.len())
+
+		if err := w.sender().send(batch); err != nil {
+			failedWrites.Add(w.Name, 1)
+			droppedPoints.Add(w.Name, int64(batch.len()))
+			log.Warningf("Failed to write %v %v to %v sink: %v",
+				batch.len(), batch.unit(), w.Name, trace.UserMessage(err))
+			continue
+		}
+		log.Debugf("Sent %v %v to %v sink", batch.len(), batch.unit(), w.Name)
+	}
+}
@@ -0,0 +1,128 @@
+package sink
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func newTestBatchWriter(t *testing.T, writer Writer, batchSize, maxBuffered int, policy string) *BatchWriter {
+	w, err := NewBatchWriter(BatchConfig{
+		Name:              fmt.Sprintf("test-%v", time.Now().UnixNano()),
+		Writer:            writer,
+		BatchSize:         batchSize,
+		FlushInterval:     time.Hour,
+		MaxBufferedPoints: maxBuffered,
+		DropPolicy:        policy,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return w
+}
+
+// counter returns value of the counter of the sink
+func counter(m *expvar.Map, name string) int64 {
+	v, ok := m.Get(name).(*expvar.Int)
+	if !ok {
+		return 0
+	}
+	return v.Value()
+}
+
+func TestBatchWriterDropPolicies(t *testing.T) {
+	tests := []struct {
+		policy  string
+		written [][]string
+	}{
+		{policy: DropOldest, written: [][]string{lines(testPoints(t, 3, 4, 5))}},
+		{policy: DropNewest, written: [][]string{lines(testPoints(t, 1, 2, 3))}},
+	}
+	for _, test := range tests {
+		writer := &fakeWriter{}
+		w := newTestBatchWriter(t, writer, 3, 3, test.policy)
+		if err := w.Write(context.TODO(), testPoints(t, 1, 2, 3, 4, 5)); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if written := writer.getWritten(); !reflect.DeepEqual(written, test.written) {
+			t.Errorf("%v: expected %v to be written, got %v", test.policy, test.written, written)
+		}
+		if dropped := counter(droppedPoints, w.Name); dropped != 2 {
+			t.Errorf("%v: expected 2 dropped points, got %v", test.policy, dropped)
+		}
+	}
+}
+
+func TestBatchWriterSplitsFlushIntoBatches(t *testing.T) {
+	writer := &fakeWriter{}
+	w := newTestBatchWriter(t, writer, 2, 10, DropOldest)
+	if err := w.Write(context.TODO(), testPoints(t, 1, 2, 3, 4, 5)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	expected := [][]string{lines(testPoints(t, 1, 2)), lines(testPoints(t, 3, 4)), lines(testPoints(t, 5))}
+	if written := writer.getWritten(); !reflect.DeepEqual(written, expected) {
+		t.Errorf("expected %v to be written, got %v", expected, written)
+	}
+}
+
+func TestBatchWriterCountsFailedBatches(t *testing.T) {
+	writer := &fakeWriter{errors: []error{permanentErr}}
+	w := newTestBatchWriter(t, writer, 2, 10, DropOldest)
+	if err := w.Write(context.TODO(), testPoints(t, 1, 2, 3, 4, 5)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	expected := [][]string{lines(testPoints(t, 3, 4)), lines(testPoints(t, 5))}
+	if written := writer.getWritten(); !reflect.DeepEqual(written, expected) {
+		t.Errorf("expected batches after the failed one to be written, got %v", written)
+	}
+	if failed := counter(failedWrites, w.Name); failed != 1 {
+		t.Errorf("expected 1 failed write, got %v", failed)
+	}
+	if dropped := counter(droppedPoints, w.Name); dropped != 2 {
+		t.Errorf("expected points of the failed batch to be dropped, got %v", dropped)
+	}
+}
+
+func TestBatchWriterWritesSamples(t *testing.T) {
+	writer := &fakeSampleWriter{}
+	w, err := NewBatchWriter(BatchConfig{
+		Name:              fmt.Sprintf("test-%v", time.Now().UnixNano()),
+		SampleWriter:      DropLabels(writer, "job"),
+		BatchSize:         2,
+		FlushInterval:     time.Hour,
+		MaxBufferedPoints: 10,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	samples := testSamples()
+	if err := w.WriteSamples(context.TODO(), samples); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Write(context.TODO(), testPoints(t, 1)); err == nil {
+		t.Error("expected points to be rejected by sink of samples")
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	expected := testSamples()
+	for _, sample := range expected {
+		delete(sample.Labels, "job")
+	}
+	checkSamples(t, expected, writer.getWritten())
+	if samples[0].Labels["job"] == "" {
+		t.Error("expected labels of written samples not to be modified")
+	}
+}
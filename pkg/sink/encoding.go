@@ -0,0 +1,115 @@
+package sink
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/gravitational/trace"
+	"github.com/influxdata/influxdb/client/v2"
+	"github.com/influxdata/influxdb/models"
+
+	"github.com/gravitational/mm/pkg/prometheus"
+)
+
+// batch is a slice of points or samples buffered, retried and persisted
+// together, batches of samples are never converted to points which can't
+// hold NaN and infinite values
+type batch interface {
+	// len returns the number of items in the batch
+	len() int
+	// slice returns items of the batch from i to j
+	slice(i, j int) batch
+	// append returns the batch with items of the other batch of the same kind
+	append(other batch) batch
+	// unit names items in logs
+	unit() string
+	// encode writes items of the batch one per line
+	encode(buf *bytes.Buffer)
+	// decode returns a batch of the same kind made of encoded items,
+	// items which could be decoded are returned along with the error
+	decode(data []byte) (batch, error)
+}
+
+// pointBatch is a batch of points encoded in line protocol
+type pointBatch []*client.Point
+
+func (b pointBatch) len() int                 { return len(b) }
+func (b pointBatch) slice(i, j int) batch     { return b[i:j] }
+func (b pointBatch) append(other batch) batch { return append(b, other.(pointBatch)...) }
+func (b pointBatch) unit() string             { return "points" }
+
+func (b pointBatch) encode(buf *bytes.Buffer) {
+	for _, p := range b {
+		buf.WriteString(p.String())
+		buf.WriteByte('\n')
+	}
+}
+
+func (b pointBatch) decode(data []byte) (batch, error) {
+	parsed, err := models.ParsePoints(data)
+	points := make(pointBatch, 0, len(parsed))
+	for _, p := range parsed {
+		points = append(points, client.NewPointFrom(p))
+	}
+	return points, trace.Wrap(err)
+}
+
+// sampleBatch is a batch of samples, a sample is encoded as bits of its value,
+// its timestamp and its labels in JSON so that NaN values, e.g. staleness
+// markers, are kept as they are
+type sampleBatch []prometheus.Sample
+
+func (b sampleBatch) len() int                 { return len(b) }
+func (b sampleBatch) slice(i, j int) batch     { return b[i:j] }
+func (b sampleBatch) append(other batch) batch { return append(b, other.(sampleBatch)...) }
+func (b sampleBatch) unit() string             { return "samples" }
+
+func (b sampleBatch) encode(buf *bytes.Buffer) {
+	for _, sample := range b {
+		// map of strings always marshals
+		labels, _ := json.Marshal(sample.Labels)
+		fmt.Fprintf(buf, "%016x %d %s\n", math.Float64bits(sample.Value), sample.Timestamp, labels)
+	}
+}
+
+func (b sampleBatch) decode(data []byte) (batch, error) {
+	var samples sampleBatch
+	var errors []error
+	for _, line := range strings.Split(string(data), "\n") {
+		if line == "" {
+			continue
+		}
+		sample, err := decodeSample(line)
+		if err != nil {
+			errors = append(errors, err)
+			continue
+		}
+		samples = append(samples, *sample)
+	}
+	return samples, trace.NewAggregate(errors...)
+}
+
+// decodeSample parses the line made by sampleBatch.encode
+func decodeSample(line string) (*prometheus.Sample, error) {
+	parts := strings.SplitN(line, " ", 3)
+	if len(parts) != 3 {
+		return nil, trace.BadParameter("invalid sample %q", line)
+	}
+	bits, err := strconv.ParseUint(parts[0], 16, 64)
+	if err != nil {
+		return nil, trace.BadParameter("invalid value of sample %q", line)
+	}
+	timestamp, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return nil, trace.BadParameter("invalid timestamp of sample %q", line)
+	}
+	var labels map[string]string
+	if err := json.Unmarshal([]byte(parts[2]), &labels); err != nil {
+		return nil, trace.BadParameter("invalid labels of sample %q", line)
+	}
+	return &prometheus.Sample{Labels: labels, Value: math.Float64frombits(bits), Timestamp: timestamp}, nil
+}
@@ -0,0 +1,31 @@
+package sink
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// DefaultTimeout is a default timeout of a single write to a backend
+const DefaultTimeout = 30 * time.Second
+
+// HTTPError is an error response of a backend HTTP API
+type HTTPError struct {
+	// Backend names the backend, e.g. InfluxDB
+	Backend string
+	// StatusCode is HTTP status code of the response
+	StatusCode int
+	// Message is an error message returned by the backend
+	Message string
+}
+
+func (e *HTTPError) Error() string {
+	return fmt.Sprintf("%v returned HTTP status %v %s: %s",
+		e.Backend, e.StatusCode, http.StatusText(e.StatusCode), e.Message)
+}
+
+// Retryable returns true if the write may succeed when retried,
+// that is for server errors and throttling
+func (e *HTTPError) Retryable() bool {
+	return e.StatusCode >= http.StatusInternalServerError || e.StatusCode == http.StatusTooManyRequests
+}
@@ -0,0 +1,118 @@
+package sink
+
+import (
+	"expvar"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/gravitational/trace"
+	"github.com/influxdata/influxdb/client/v2"
+
+	"github.com/gravitational/mm/pkg/prometheus"
+	"github.com/gravitational/mm/pkg/util"
+)
+
+// retriedWrites counts retries of failed writes of each sink
+var retriedWrites = expvar.NewMap("sink_retried_writes")
+
+type RetryConfig struct {
+	// Name identifies the sink in logs and counters
+	Name string
+	// Writer writes points
+	Writer Writer
+	// SampleWriter writes samples of sinks of samples instead of Writer
+	SampleWriter SampleWriter
+	// MaxRetries is a maximum number of retries of a single write
+	MaxRetries int
+	// InitialBackoff is a delay before the first retry
+	InitialBackoff time.Duration
+	// MaxBackoff caps the delay between retries
+	MaxBackoff time.Duration
+}
+
+func (c *RetryConfig) CheckAndSetDefaults() error {
+	if c.Name == "" {
+		return trace.BadParameter("missing parameter Name")
+	}
+	if err := checkWriters(c.Writer, c.SampleWriter); err != nil {
+		return trace.Wrap(err)
+	}
+	if c.MaxRetries < 0 {
+		return trace.BadParameter("max retries should not be negative, got %v", c.MaxRetries)
+	}
+	if c.InitialBackoff <= 0 || c.MaxBackoff < c.InitialBackoff {
+		return trace.BadParameter("invalid retry backoff from %v to %v", c.InitialBackoff, c.MaxBackoff)
+	}
+	return nil
+}
+
+// RetryWriter retries writes failed with retryable errors
+// with jittered exponential backoff
+type RetryWriter struct {
+	RetryConfig
+	// stopC interrupts retries once closed
+	stopC    chan struct{}
+	stopOnce sync.Once
+}
+
+func NewRetryWriter(config RetryConfig) (*RetryWriter, error) {
+	if err := config.CheckAndSetDefaults(); err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return &RetryWriter{RetryConfig: config, stopC: make(chan struct{})}, nil
+}
+
+func (w *RetryWriter) Send(points []*client.Point) error {
+	return trace.Wrap(w.send(pointBatch(points)))
+}
+
+func (w *RetryWriter) SendSamples(samples []prometheus.Sample) error {
+	return trace.Wrap(w.send(sampleBatch(samples)))
+}
+
+func (w *RetryWriter) send(items batch) error {
+	backoff := util.Backoff{Initial: w.InitialBackoff, Max: w.MaxBackoff}
+	for retry := 1; ; retry++ {
+		err := w.sender().send(items)
+		if err == nil {
+			return nil
+		}
+		if !IsRetryable(err) || retry > w.MaxRetries || w.stopped() {
+			return trace.Wrap(err)
+		}
+		delay := backoff.Next()
+		retriedWrites.Add(w.Name, 1)
+		log.Warningf("Failed to write %v %v to %v sink, retry %v of %v in %v: %v",
+			items.len(), items.unit(), w.Name, retry, w.MaxRetries, delay, trace.UserMessage(err))
+		select {
+		case <-time.After(delay):
+		case <-w.stopC:
+			return trace.Wrap(err)
+		}
+	}
+}
+
+func (w *RetryWriter) sender() sender {
+	return sender{writer: w.Writer, sampleWriter: w.SampleWriter}
+}
+
+// stop interrupts retries, writes are attempted once after it is called
+func (w *RetryWriter) stop() {
+	w.stopOnce.Do(func() { close(w.stopC) })
+}
+
+func (w *RetryWriter) stopped() bool {
+	select {
+	case <-w.stopC:
+		return true
+	default:
+		return false
+	}
+}
+
+// Close stops retries and closes the backend
+func (w *RetryWriter) Close() error {
+	w.stop()
+	return w.sender().close()
+}
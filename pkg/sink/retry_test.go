@@ -0,0 +1,83 @@
+package sink
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRetryWriterRetriesRetryableErrors(t *testing.T) {
+	writer := &fakeWriter{errors: []error{retryableErr, retryableErr}}
+	w, err := NewRetryWriter(RetryConfig{
+		Name:           "test",
+		Writer:         writer,
+		MaxRetries:     3,
+		InitialBackoff: time.Millisecond,
+		MaxBackoff:     time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Send(testPoints(t, 1)); err != nil {
+		t.Fatal(err)
+	}
+	if writer.attempts != 3 {
+		t.Errorf("expected 3 attempts, got %v", writer.attempts)
+	}
+
+	writer = &fakeWriter{errors: []error{permanentErr}}
+	w.Writer = writer
+	if err := w.Send(testPoints(t, 1)); err == nil {
+		t.Error("expected permanent error to be returned")
+	}
+	if writer.attempts != 1 {
+		t.Errorf("expected permanent error not to be retried, got %v attempts", writer.attempts)
+	}
+}
+
+func TestCloseInterruptsRetries(t *testing.T) {
+	writer := &fakeWriter{alwaysFail: true, startedC: make(chan struct{}, 10)}
+	retry, err := NewRetryWriter(RetryConfig{
+		Name:           "test",
+		Writer:         writer,
+		MaxRetries:     10,
+		InitialBackoff: time.Hour,
+		MaxBackoff:     time.Hour,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := NewBatchWriter(BatchConfig{
+		Name:              "test",
+		Writer:            retry,
+		BatchSize:         1,
+		FlushInterval:     time.Hour,
+		MaxBufferedPoints: 10,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Write(context.TODO(), testPoints(t, 1)); err != nil {
+		t.Fatal(err)
+	}
+	// the first write has failed and is waiting for a retry
+	<-writer.startedC
+
+	closedC := make(chan error, 1)
+	go func() {
+		closedC <- w.Close()
+	}()
+	select {
+	case err := <-closedC:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for close")
+	}
+	writer.Lock()
+	defer writer.Unlock()
+	if writer.attempts != 1 {
+		t.Errorf("expected the write not to be retried after close, got %v attempts", writer.attempts)
+	}
+}
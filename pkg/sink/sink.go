@@ -0,0 +1,179 @@
+package sink
+
+import (
+	"context"
+	"expvar"
+	"io"
+
+	"github.com/gravitational/trace"
+	"github.com/influxdata/influxdb/client/v2"
+
+	"github.com/gravitational/mm/pkg/prometheus"
+)
+
+var (
+	// droppedPoints counts points dropped by each sink because of
+	// full buffer or failed writes
+	droppedPoints = expvar.NewMap("sink_dropped_points")
+	// failedWrites counts failed writes of each sink
+	failedWrites = expvar.NewMap("sink_failed_writes")
+)
+
+// Sink receives scraped points
+type Sink interface {
+	// Write accepts points for writing, it should not block on slow backends
+	Write(ctx context.Context, points []*client.Point) error
+	// Close writes pending points and releases resources
+	Close() error
+}
+
+// SampleSink receives scraped Prometheus samples, it is implemented by sinks
+// storing metrics the way Prometheus does rather than as InfluxDB points
+type SampleSink interface {
+	// WriteSamples accepts samples for writing, it should not block on slow backends
+	WriteSamples(ctx context.Context, samples []prometheus.Sample) error
+	// Close writes pending samples and releases resources
+	Close() error
+}
+
+// Writer synchronously writes points to a backend
+type Writer interface {
+	Send(points []*client.Point) error
+}
+
+// SampleWriter synchronously writes samples to a backend
+type SampleWriter interface {
+	SendSamples(samples []prometheus.Sample) error
+}
+
+// Fanout writes points to all of the sinks, a failure of one sink
+// does not prevent writes to the others
+type Fanout []Sink
+
+func (f Fanout) Write(ctx context.Context, points []*client.Point) error {
+	var errors []error
+	for _, sink := range f {
+		if err := sink.Write(ctx, points); err != nil {
+			errors = append(errors, err)
+		}
+	}
+	return trace.NewAggregate(errors...)
+}
+
+func (f Fanout) Close() error {
+	var errors []error
+	for _, sink := range f {
+		if err := sink.Close(); err != nil {
+			errors = append(errors, err)
+		}
+	}
+	return trace.NewAggregate(errors...)
+}
+
+// Retryable is implemented by backend errors that know
+// whether the write may succeed when retried
+type Retryable interface {
+	Retryable() bool
+}
+
+// IsRetryable returns true if the write may succeed when retried,
+// an aggregate error is retryable if any of its errors is
+func IsRetryable(err error) bool {
+	if aggregate, ok := trace.Unwrap(err).(trace.Aggregate); ok {
+		for _, err := range aggregate.Errors() {
+			if IsRetryable(err) {
+				return true
+			}
+		}
+		return false
+	}
+	if trace.IsConnectionProblem(err) {
+		return true
+	}
+	retryable, ok := trace.Unwrap(err).(Retryable)
+	return ok && retryable.Retryable()
+}
+
+// stopper is implemented by writers retrying failed writes, stop makes
+// them give up retries so that closing a sink does not wait for them
+type stopper interface {
+	stop()
+}
+
+// stopWriter interrupts retries of the writer if it retries writes
+func stopWriter(w interface{}) {
+	if s, ok := w.(stopper); ok {
+		s.stop()
+	}
+}
+
+// closeWriter closes the writer if it holds any resources
+func closeWriter(w interface{}) error {
+	if closer, ok := w.(io.Closer); ok {
+		return trace.Wrap(closer.Close())
+	}
+	return nil
+}
+
+// checkWriters checks that a sink writes either points or samples
+func checkWriters(writer Writer, sampleWriter SampleWriter) error {
+	if writer == nil && sampleWriter == nil {
+		return trace.BadParameter("missing parameter Writer or SampleWriter")
+	}
+	if writer != nil && sampleWriter != nil {
+		return trace.BadParameter("only one of Writer and SampleWriter should be set")
+	}
+	return nil
+}
+
+// sender writes batches with the writer of points or the writer of samples,
+// only one of them is set
+type sender struct {
+	writer       Writer
+	sampleWriter SampleWriter
+}
+
+// check returns an error unless the sink writes batches of the given kind
+func (s sender) check(b batch) error {
+	switch b.(type) {
+	case pointBatch:
+		if s.writer != nil {
+			return nil
+		}
+	case sampleBatch:
+		if s.sampleWriter != nil {
+			return nil
+		}
+	}
+	return trace.BadParameter("sink does not accept %v", b.unit())
+}
+
+func (s sender) send(b batch) error {
+	if err := s.check(b); err != nil {
+		return trace.Wrap(err)
+	}
+	if samples, ok := b.(sampleBatch); ok {
+		return s.sampleWriter.SendSamples(samples)
+	}
+	return s.writer.Send(b.(pointBatch))
+}
+
+// empty returns an empty batch of the kind the sink writes
+func (s sender) empty() batch {
+	if s.sampleWriter != nil {
+		return sampleBatch(nil)
+	}
+	return pointBatch(nil)
+}
+
+func (s sender) stop() {
+	stopWriter(s.writer)
+	stopWriter(s.sampleWriter)
+}
+
+func (s sender) close() error {
+	if s.sampleWriter != nil {
+		return closeWriter(s.sampleWriter)
+	}
+	return closeWriter(s.writer)
+}
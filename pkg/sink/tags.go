@@ -0,0 +1,103 @@
+package sink
+
+import (
+	log "github.com/Sirupsen/logrus"
+	"github.com/gravitational/trace"
+	"github.com/influxdata/influxdb/client/v2"
+
+	"github.com/gravitational/mm/pkg/prometheus"
+)
+
+// DropTags returns writer removing the tags from points before writing them
+func DropTags(writer Writer, tags ...string) Writer {
+	return &tagDropper{Writer: writer, tags: tags}
+}
+
+type tagDropper struct {
+	Writer
+	tags []string
+}
+
+func (d *tagDropper) Send(points []*client.Point) error {
+	result := make([]*client.Point, 0, len(points))
+	for _, p := range points {
+		untagged, err := WithoutTags(p, d.tags...)
+		if err != nil {
+			log.Warningf("Skipping point %v: %v", p.Name(), err)
+			continue
+		}
+		result = append(result, untagged)
+	}
+	return trace.Wrap(d.Writer.Send(result))
+}
+
+// WithoutTags returns the point without the tags, the point itself
+// is returned if it has none of them
+func WithoutTags(p *client.Point, names ...string) (*client.Point, error) {
+	tags := p.Tags()
+	found := false
+	for _, name := range names {
+		if _, ok := tags[name]; ok {
+			delete(tags, name)
+			found = true
+		}
+	}
+	if !found {
+		return p, nil
+	}
+	fields, err := p.Fields()
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return client.NewPoint(p.Name(), tags, fields, p.Time())
+}
+
+func (d *tagDropper) stop() {
+	stopWriter(d.Writer)
+}
+
+// Close closes the backend
+func (d *tagDropper) Close() error {
+	return closeWriter(d.Writer)
+}
+
+// DropLabels returns writer removing the labels from samples before writing them
+func DropLabels(writer SampleWriter, labels ...string) SampleWriter {
+	return &labelDropper{SampleWriter: writer, labels: labels}
+}
+
+type labelDropper struct {
+	SampleWriter
+	labels []string
+}
+
+func (d *labelDropper) SendSamples(samples []prometheus.Sample) error {
+	result := make([]prometheus.Sample, 0, len(samples))
+	for _, sample := range samples {
+		result = append(result, withoutLabels(sample, d.labels...))
+	}
+	return trace.Wrap(d.SampleWriter.SendSamples(result))
+}
+
+// withoutLabels returns the sample without the labels, labels of the sample
+// itself are not modified
+func withoutLabels(sample prometheus.Sample, names ...string) prometheus.Sample {
+	labels := make(map[string]string, len(sample.Labels))
+	for name, value := range sample.Labels {
+		labels[name] = value
+	}
+	for _, name := range names {
+		delete(labels, name)
+	}
+	sample.Labels = labels
+	return sample
+}
+
+func (d *labelDropper) stop() {
+	stopWriter(d.SampleWriter)
+}
+
+// Close closes the backend
+func (d *labelDropper) Close() error {
+	return closeWriter(d.SampleWriter)
+}
@@ -0,0 +1,67 @@
+package sink
+
+import (
+	"sort"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/influxdata/influxdb/client/v2"
+)
+
+// Value is a single numeric field of a point, it is written
+// by sinks storing a value per series
+type Value struct {
+	// Name is a measurement of the point
+	Name string
+	// Field is a field name of the value
+	Field string
+	// Tags are tags of the point
+	Tags map[string]string
+	// Value is a value of the field
+	Value float64
+	// Time is a timestamp of the point
+	Time time.Time
+}
+
+// Values splits points into values of their numeric fields, fields of each point are sorted by name
+func Values(points []*client.Point) []Value {
+	var values []Value
+	for _, p := range points {
+		fields, err := p.Fields()
+		if err != nil {
+			log.Warningf("Skipping point %v with invalid fields: %v", p.Name(), err)
+			continue
+		}
+		names := make([]string, 0, len(fields))
+		for name := range fields {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		tags := p.Tags()
+		for _, name := range names {
+			value, ok := toFloat(fields[name])
+			if !ok {
+				continue
+			}
+			values = append(values, Value{Name: p.Name(), Field: name, Tags: tags, Value: value, Time: p.Time()})
+		}
+	}
+	return values
+}
+
+func toFloat(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case int64:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	case bool:
+		if v {
+			return 1, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
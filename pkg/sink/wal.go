@@ -0,0 +1,386 @@
+package sink
+
+import (
+	"bytes"
+	"expvar"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/gravitational/trace"
+	"github.com/influxdata/influxdb/client/v2"
+
+	"github.com/gravitational/mm/pkg/prometheus"
+	"github.com/gravitational/mm/pkg/util"
+)
+
+const (
+	// segmentExt is an extension of WAL segment files
+	segmentExt = ".wal"
+	// tempExt is an extension of segment files being written
+	tempExt = ".tmp"
+)
+
+var (
+	// walSegments is a number of segments of each sink waiting to be written
+	walSegments = expvar.NewMap("sink_wal_segments")
+	// walBytes is a size of segments of each sink waiting to be written
+	walBytes = expvar.NewMap("sink_wal_bytes")
+	// walEvictedPoints counts points evicted because of the disk size limit
+	walEvictedPoints = expvar.NewMap("sink_wal_evicted_points")
+)
+
+type WALConfig struct {
+	// Name identifies the sink in logs and counters
+	Name string
+	// Writer writes replayed points
+	Writer Writer
+	// SampleWriter writes replayed samples of sinks of samples instead of Writer
+	SampleWriter SampleWriter
+	// Dir is a directory of segment files
+	Dir string
+	// MaxSize is a maximum total size of segment files in bytes
+	MaxSize int64
+	// InitialBackoff is a delay before the first replay after a failed write
+	InitialBackoff time.Duration
+	// MaxBackoff caps the delay between replays
+	MaxBackoff time.Duration
+}
+
+func (c *WALConfig) CheckAndSetDefaults() error {
+	if c.Name == "" {
+		return trace.BadParameter("missing parameter Name")
+	}
+	if err := checkWriters(c.Writer, c.SampleWriter); err != nil {
+		return trace.Wrap(err)
+	}
+	if c.Dir == "" {
+		return trace.BadParameter("missing parameter Dir")
+	}
+	if c.MaxSize <= 0 {
+		return trace.BadParameter("max WAL size should be positive, got %v", c.MaxSize)
+	}
+	if c.InitialBackoff <= 0 || c.MaxBackoff < c.InitialBackoff {
+		return trace.BadParameter("invalid replay backoff from %v to %v", c.InitialBackoff, c.MaxBackoff)
+	}
+	return nil
+}
+
+// segment is a file with a single batch of points encoded in line protocol
+// or samples encoded one per line
+type segment struct {
+	seq    uint64
+	size   int64
+	points int
+}
+
+// WAL persists batches of points or samples in segment files and replays
+// them in order in background, a segment is removed once it is written
+type WAL struct {
+	WALConfig
+	sync.Mutex
+	segments []segment
+	size     int64
+	nextSeq  uint64
+	// segmentsVar and bytesVar publish the size of the WAL
+	segmentsVar expvar.Int
+	bytesVar    expvar.Int
+	// notifyC wakes up replay when a segment is appended
+	notifyC   chan struct{}
+	closeC    chan struct{}
+	closeOnce sync.Once
+	doneC     chan struct{}
+}
+
+// NewWAL opens the WAL directory and starts replaying segments left
+// from the previous run
+func NewWAL(config WALConfig) (*WAL, error) {
+	if err := config.CheckAndSetDefaults(); err != nil {
+		return nil, trace.Wrap(err)
+	}
+	if err := os.MkdirAll(config.Dir, 0700); err != nil {
+		return nil, trace.ConvertSystemError(err)
+	}
+	w := &WAL{
+		WALConfig: config,
+		notifyC:   make(chan struct{}, 1),
+		closeC:    make(chan struct{}),
+		doneC:     make(chan struct{}),
+	}
+	walSegments.Set(config.Name, &w.segmentsVar)
+	walBytes.Set(config.Name, &w.bytesVar)
+	if err := w.load(); err != nil {
+		return nil, trace.Wrap(err)
+	}
+	if len(w.segments) > 0 {
+		log.Infof("Replaying %v WAL segments of %v sink of %v bytes from %v",
+			len(w.segments), w.Name, w.size, w.Dir)
+	}
+	go w.run()
+	return w, nil
+}
+
+// load reads the list of segments from the directory
+func (w *WAL) load() error {
+	files, err := ioutil.ReadDir(w.Dir)
+	if err != nil {
+		return trace.ConvertSystemError(err)
+	}
+	for _, file := range files {
+		name := file.Name()
+		if strings.HasSuffix(name, tempExt) {
+			// segment was not completely written before a crash
+			if err := os.Remove(filepath.Join(w.Dir, name)); err != nil {
+				return trace.ConvertSystemError(err)
+			}
+			continue
+		}
+		if !strings.HasSuffix(name, segmentExt) {
+			continue
+		}
+		seq, err := strconv.ParseUint(strings.TrimSuffix(name, segmentExt), 10, 64)
+		if err != nil {
+			log.Warningf("Ignoring unexpected file %v in WAL directory", name)
+			continue
+		}
+		data, err := ioutil.ReadFile(filepath.Join(w.Dir, name))
+		if err != nil {
+			return trace.ConvertSystemError(err)
+		}
+		w.segments = append(w.segments, segment{
+			seq:    seq,
+			size:   file.Size(),
+			points: bytes.Count(data, []byte{'\n'}),
+		})
+		w.size += file.Size()
+	}
+	sort.Sort(bySeq(w.segments))
+	if len(w.segments) > 0 {
+		w.nextSeq = w.segments[len(w.segments)-1].seq + 1
+	}
+	w.updateStats()
+	return nil
+}
+
+// Send persists the points as a new segment, evicting the oldest segments
+// if the disk size limit is exceeded
+func (w *WAL) Send(points []*client.Point) error {
+	return trace.Wrap(w.append(pointBatch(points)))
+}
+
+// SendSamples persists the samples the same way Send persists points
+func (w *WAL) SendSamples(samples []prometheus.Sample) error {
+	return trace.Wrap(w.append(sampleBatch(samples)))
+}
+
+func (w *WAL) append(items batch) error {
+	if err := w.sender().check(items); err != nil {
+		return trace.Wrap(err)
+	}
+	var buf bytes.Buffer
+	items.encode(&buf)
+	size := int64(buf.Len())
+	if size > w.MaxSize {
+		return trace.LimitExceeded("batch of %v bytes exceeds max WAL size of %v bytes", size, w.MaxSize)
+	}
+
+	w.Lock()
+	defer w.Unlock()
+
+	for w.size+size > w.MaxSize && len(w.segments) > 0 {
+		oldest := w.segments[0]
+		if err := w.remove(oldest); err != nil {
+			return trace.Wrap(err)
+		}
+		walEvictedPoints.Add(w.Name, int64(oldest.points))
+		droppedPoints.Add(w.Name, int64(oldest.points))
+		log.Warningf("WAL size limit of %v sink reached, evicted segment with %v %v",
+			w.Name, oldest.points, items.unit())
+	}
+
+	seg := segment{seq: w.nextSeq, size: size, points: items.len()}
+	if err := writeFile(w.path(seg), buf.Bytes()); err != nil {
+		return trace.Wrap(err)
+	}
+	w.nextSeq++
+	w.segments = append(w.segments, seg)
+	w.size += size
+	w.updateStats()
+
+	select {
+	case w.notifyC <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Close stops replay and closes the backend,
+// segments that were not written are kept on disk
+func (w *WAL) Close() error {
+	var err error
+	w.closeOnce.Do(func() {
+		close(w.closeC)
+		<-w.doneC
+		err = w.sender().close()
+	})
+	return trace.Wrap(err)
+}
+
+func (w *WAL) sender() sender {
+	return sender{writer: w.Writer, sampleWriter: w.SampleWriter}
+}
+
+func (w *WAL) run() {
+	defer close(w.doneC)
+	backoff := util.Backoff{Initial: w.InitialBackoff, Max: w.MaxBackoff}
+	for {
+		err := w.replay()
+		var delayC <-chan time.Time
+		if err != nil {
+			delay := backoff.Next()
+			log.Warningf("Failed to replay WAL of %v sink, next attempt in %v: %v",
+				w.Name, delay, trace.UserMessage(err))
+			delayC = time.After(delay)
+		} else {
+			backoff.Reset()
+		}
+		select {
+		case <-delayC:
+		case <-w.notifyC:
+			if delayC != nil {
+				// keep backing off while the writer fails
+				select {
+				case <-delayC:
+				case <-w.closeC:
+					return
+				}
+			}
+		case <-w.closeC:
+			return
+		}
+	}
+}
+
+// replay writes segments in order until all of them are written or
+// a write fails with retryable error
+func (w *WAL) replay() error {
+	for {
+		select {
+		case <-w.closeC:
+			return nil
+		default:
+		}
+
+		w.Lock()
+		if len(w.segments) == 0 {
+			w.Unlock()
+			return nil
+		}
+		seg := w.segments[0]
+		w.Unlock()
+
+		items, err := w.read(seg)
+		if err != nil && !trace.IsNotFound(err) {
+			return trace.Wrap(err)
+		}
+		if err == nil {
+			err = w.sender().send(items)
+			if err != nil && IsRetryable(err) {
+				return trace.Wrap(err)
+			}
+			if err != nil {
+				failedWrites.Add(w.Name, 1)
+				droppedPoints.Add(w.Name, int64(items.len()))
+				log.Warningf("Failed to write WAL segment %v with %v %v to %v sink, dropping it: %v",
+					seg.seq, items.len(), items.unit(), w.Name, trace.UserMessage(err))
+			}
+		}
+
+		if err := w.ack(seg); err != nil {
+			return trace.Wrap(err)
+		}
+	}
+}
+
+// ack removes the segment once it has been written
+func (w *WAL) ack(seg segment) error {
+	w.Lock()
+	defer w.Unlock()
+	// the segment may have been evicted while it was written
+	if len(w.segments) == 0 || w.segments[0].seq != seg.seq {
+		return nil
+	}
+	return trace.Wrap(w.remove(seg))
+}
+
+// read decodes points or samples of the segment
+func (w *WAL) read(seg segment) (batch, error) {
+	data, err := ioutil.ReadFile(w.path(seg))
+	if err != nil {
+		return nil, trace.ConvertSystemError(err)
+	}
+	items, err := w.sender().empty().decode(data)
+	if err != nil {
+		// items which could be decoded are returned along with the error
+		log.Warningf("Corrupted WAL segment %v, %v %v recovered: %v", seg.seq, items.len(), items.unit(), err)
+	}
+	return items, nil
+}
+
+// remove deletes the first segment, it should be called under the lock
+func (w *WAL) remove(seg segment) error {
+	if err := os.Remove(w.path(seg)); err != nil && !os.IsNotExist(err) {
+		return trace.ConvertSystemError(err)
+	}
+	w.segments = w.segments[1:]
+	w.size -= seg.size
+	w.updateStats()
+	return nil
+}
+
+func (w *WAL) updateStats() {
+	w.segmentsVar.Set(int64(len(w.segments)))
+	w.bytesVar.Set(w.size)
+}
+
+func (w *WAL) path(seg segment) string {
+	return filepath.Join(w.Dir, fmt.Sprintf("%020d%s", seg.seq, segmentExt))
+}
+
+// writeFile writes the file atomically so that a crash never leaves
+// a partially written segment
+func writeFile(path string, data []byte) error {
+	tempPath := path + tempExt
+	f, err := os.OpenFile(tempPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return trace.ConvertSystemError(err)
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tempPath, path)
+	}
+	if err != nil {
+		os.Remove(tempPath)
+		return trace.ConvertSystemError(err)
+	}
+	return nil
+}
+
+type bySeq []segment
+
+func (s bySeq) Len() int           { return len(s) }
+func (s bySeq) Less(i, j int) bool { return s[i].seq < s[j].seq }
+func (s bySeq) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
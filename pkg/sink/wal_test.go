@@ -0,0 +1,372 @@
+package sink
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gravitational/trace"
+	"github.com/influxdata/influxdb/client/v2"
+
+	"github.com/gravitational/mm/pkg/prometheus"
+)
+
+// fakeWriter records written points and fails writes with the given errors
+type fakeWriter struct {
+	sync.Mutex
+	// errors are returned by consecutive writes, nil once they run out
+	errors []error
+	// alwaysFail makes every write fail with retryable error
+	alwaysFail bool
+	// startedC receives a value when a write starts if set
+	startedC chan struct{}
+	// releaseC blocks writes until it receives a value if set
+	releaseC chan struct{}
+	// written are lines of written points by write
+	written [][]string
+	// attempts is a number of writes
+	attempts int
+}
+
+func (w *fakeWriter) Send(points []*client.Point) error {
+	if w.startedC != nil {
+		w.startedC <- struct{}{}
+	}
+	if w.releaseC != nil {
+		<-w.releaseC
+	}
+	w.Lock()
+	defer w.Unlock()
+	w.attempts++
+	if w.alwaysFail {
+		return retryableErr
+	}
+	if len(w.errors) > 0 {
+		err := w.errors[0]
+		w.errors = w.errors[1:]
+		if err != nil {
+			return err
+		}
+	}
+	w.written = append(w.written, lines(points))
+	return nil
+}
+
+// fakeSampleWriter records written samples
+type fakeSampleWriter struct {
+	sync.Mutex
+	// alwaysFail makes every write fail with retryable error
+	alwaysFail bool
+	written    []prometheus.Sample
+}
+
+func (w *fakeSampleWriter) SendSamples(samples []prometheus.Sample) error {
+	w.Lock()
+	defer w.Unlock()
+	if w.alwaysFail {
+		return retryableErr
+	}
+	w.written = append(w.written, samples...)
+	return nil
+}
+
+func (w *fakeSampleWriter) getWritten() []prometheus.Sample {
+	w.Lock()
+	defer w.Unlock()
+	return append([]prometheus.Sample(nil), w.written...)
+}
+
+// testSamples returns samples with values points can't hold,
+// including Prometheus staleness marker
+func testSamples() []prometheus.Sample {
+	return []prometheus.Sample{
+		{Labels: map[string]string{"__name__": "up", "job": "a b\n"}, Value: 1, Timestamp: 1},
+		{Labels: map[string]string{"__name__": "up", "job": "b"}, Value: math.Float64frombits(0x7ff0000000000002), Timestamp: 2},
+		{Labels: map[string]string{"__name__": "temperature"}, Value: math.Inf(+1), Timestamp: 3},
+		{Labels: map[string]string{"__name__": "ratio"}, Value: math.NaN(), Timestamp: 4},
+	}
+}
+
+// checkSamples compares samples including bits of their values
+func checkSamples(t *testing.T, expected, samples []prometheus.Sample) {
+	if len(samples) != len(expected) {
+		t.Fatalf("expected %v samples, got %v", len(expected), samples)
+	}
+	for i, sample := range samples {
+		if !reflect.DeepEqual(sample.Labels, expected[i].Labels) || sample.Timestamp != expected[i].Timestamp ||
+			math.Float64bits(sample.Value) != math.Float64bits(expected[i].Value) {
+			t.Errorf("expected sample %v, got %v", expected[i], sample)
+		}
+	}
+}
+
+func (w *fakeWriter) getWritten() [][]string {
+	w.Lock()
+	defer w.Unlock()
+	return append([][]string(nil), w.written...)
+}
+
+var (
+	retryableErr = &HTTPError{Backend: "test", StatusCode: http.StatusServiceUnavailable}
+	permanentErr = &HTTPError{Backend: "test", StatusCode: http.StatusBadRequest}
+)
+
+// testPoints returns a batch of points of the same size with the given values
+func testPoints(t *testing.T, values ...int) []*client.Point {
+	var points []*client.Point
+	for _, value := range values {
+		p, err := client.NewPoint("m", nil, map[string]interface{}{"v": float64(value)}, time.Unix(int64(value), 0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		points = append(points, p)
+	}
+	return points
+}
+
+func lines(points []*client.Point) []string {
+	var result []string
+	for _, p := range points {
+		result = append(result, p.String())
+	}
+	return result
+}
+
+func newTestWAL(t *testing.T, dir string, writer Writer, maxSize int64) *WAL {
+	w, err := NewWAL(WALConfig{
+		Name:           fmt.Sprintf("test-%v", time.Now().UnixNano()),
+		Writer:         writer,
+		Dir:            dir,
+		MaxSize:        maxSize,
+		InitialBackoff: time.Millisecond,
+		MaxBackoff:     time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return w
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "wal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+// batchSize returns size of a segment of the batch
+func batchSize(points []*client.Point) int64 {
+	var size int64
+	for _, p := range points {
+		size += int64(len(p.String())) + 1
+	}
+	return size
+}
+
+// waitFor waits until the condition is true
+func waitFor(t *testing.T, description string, condition func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting for %v", description)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func (w *WAL) seqs() []uint64 {
+	w.Lock()
+	defer w.Unlock()
+	var seqs []uint64
+	for _, seg := range w.segments {
+		seqs = append(seqs, seg.seq)
+	}
+	return seqs
+}
+
+func TestWALReplaysSamplesAsTheyAre(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	config := WALConfig{
+		Name:           fmt.Sprintf("test-%v", time.Now().UnixNano()),
+		SampleWriter:   &fakeSampleWriter{alwaysFail: true},
+		Dir:            dir,
+		MaxSize:        1 << 20,
+		InitialBackoff: time.Hour,
+		MaxBackoff:     time.Hour,
+	}
+	w, err := NewWAL(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.SendSamples(testSamples()); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Send(testPoints(t, 1)); err == nil {
+		t.Error("expected points to be rejected by WAL of samples")
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// samples left on disk are replayed by the next run
+	writer := &fakeSampleWriter{}
+	config.SampleWriter = writer
+	w, err = NewWAL(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	waitFor(t, "replay of samples", func() bool { return len(writer.getWritten()) > 0 })
+	checkSamples(t, testSamples(), writer.getWritten())
+}
+
+func TestWALEvictsOldestSegments(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	writer := &fakeWriter{alwaysFail: true}
+	w := newTestWAL(t, dir, writer, 2*batchSize(testPoints(t, 1)))
+	defer w.Close()
+
+	for value := 1; value <= 3; value++ {
+		if err := w.Send(testPoints(t, value)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if seqs := w.seqs(); !reflect.DeepEqual(seqs, []uint64{1, 2}) {
+		t.Errorf("expected segments 1 and 2 to be kept, got %v", seqs)
+	}
+	if _, err := os.Stat(filepath.Join(dir, fmt.Sprintf("%020d.wal", 0))); !os.IsNotExist(err) {
+		t.Errorf("expected evicted segment to be removed, got %v", err)
+	}
+	if err := w.Send(testPoints(t, 1, 2, 3)); err == nil {
+		t.Error("expected batch larger than the WAL to be rejected")
+	}
+}
+
+func TestWALReplaysSegmentsAfterRestart(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	w := newTestWAL(t, dir, &fakeWriter{alwaysFail: true}, 1<<20)
+	for value := 1; value <= 3; value++ {
+		if err := w.Send(testPoints(t, value)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// segment which was being written when the process crashed
+	tempPath := filepath.Join(dir, fmt.Sprintf("%020d.wal.tmp", 3))
+	if err := ioutil.WriteFile(tempPath, []byte("m v=4 4000000000\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	writer := &fakeWriter{}
+	w = newTestWAL(t, dir, writer, 1<<20)
+	defer w.Close()
+	waitFor(t, "replay", func() bool { return len(w.seqs()) == 0 })
+
+	expected := [][]string{lines(testPoints(t, 1)), lines(testPoints(t, 2)), lines(testPoints(t, 3))}
+	if written := writer.getWritten(); !reflect.DeepEqual(written, expected) {
+		t.Errorf("expected %v to be replayed, got %v", expected, written)
+	}
+	if _, err := os.Stat(tempPath); !os.IsNotExist(err) {
+		t.Errorf("expected partially written segment to be removed, got %v", err)
+	}
+
+	// new segments continue the sequence of replayed ones
+	if err := w.Send(testPoints(t, 5)); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "write", func() bool { return len(writer.getWritten()) == 4 })
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 0 {
+		t.Errorf("expected written segments to be removed, got %v files", len(files))
+	}
+}
+
+func TestWALRetriesRetryableErrors(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	// an aggregate error is retried if any of its errors is retryable
+	writer := &fakeWriter{errors: []error{retryableErr, trace.NewAggregate(permanentErr, retryableErr)}}
+	w := newTestWAL(t, dir, writer, 1<<20)
+	defer w.Close()
+
+	if err := w.Send(testPoints(t, 1)); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "write", func() bool { return len(w.seqs()) == 0 })
+	if written := writer.getWritten(); !reflect.DeepEqual(written, [][]string{lines(testPoints(t, 1))}) {
+		t.Errorf("expected batch to be written after retries, got %v", written)
+	}
+	writer.Lock()
+	defer writer.Unlock()
+	if writer.attempts != 3 {
+		t.Errorf("expected 3 attempts, got %v", writer.attempts)
+	}
+}
+
+func TestWALDropsSegmentsFailedWithPermanentErrors(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	writer := &fakeWriter{errors: []error{permanentErr}}
+	w := newTestWAL(t, dir, writer, 1<<20)
+	defer w.Close()
+
+	if err := w.Send(testPoints(t, 1)); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "drop", func() bool { return len(w.seqs()) == 0 })
+	if err := w.Send(testPoints(t, 2)); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "write", func() bool { return len(writer.getWritten()) == 1 })
+	if written := writer.getWritten(); !reflect.DeepEqual(written, [][]string{lines(testPoints(t, 2))}) {
+		t.Errorf("expected only the second batch to be written, got %v", written)
+	}
+}
+
+func TestWALKeepsSegmentsAddedWhileWrittenSegmentIsEvicted(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	writer := &fakeWriter{startedC: make(chan struct{}, 10), releaseC: make(chan struct{}, 10)}
+	w := newTestWAL(t, dir, writer, 2*batchSize(testPoints(t, 1)))
+	defer w.Close()
+
+	if err := w.Send(testPoints(t, 1)); err != nil {
+		t.Fatal(err)
+	}
+	<-writer.startedC
+	// segment 0 being written is evicted by the new ones
+	for value := 2; value <= 3; value++ {
+		if err := w.Send(testPoints(t, value)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if seqs := w.seqs(); !reflect.DeepEqual(seqs, []uint64{1, 2}) {
+		t.Fatalf("expected segments 1 and 2, got %v", seqs)
+	}
+	for i := 0; i < 3; i++ {
+		writer.releaseC <- struct{}{}
+	}
+	waitFor(t, "replay", func() bool { return len(w.seqs()) == 0 })
+
+	expected := [][]string{lines(testPoints(t, 1)), lines(testPoints(t, 2)), lines(testPoints(t, 3))}
+	if written := writer.getWritten(); !reflect.DeepEqual(written, expected) {
+		t.Errorf("expected %v to be written, got %v", expected, written)
+	}
+}
@@ -0,0 +1,40 @@
+package util
+
+import (
+	"math/rand"
+	"time"
+)
+
+// Backoff computes jittered exponentially growing delays between retries
+type Backoff struct {
+	// Initial is a delay after the first failure
+	Initial time.Duration
+	// Max caps the delay
+	Max time.Duration
+	// attempt is a number of failures since the last reset
+	attempt uint
+}
+
+// Next returns a delay before the next retry
+func (b *Backoff) Next() time.Duration {
+	delay := b.Initial
+	for i := uint(0); i < b.attempt && delay < b.Max; i++ {
+		delay *= 2
+	}
+	if delay > b.Max {
+		delay = b.Max
+	}
+	b.attempt++
+	// randomize the second half of the delay so that clients
+	// failed at the same time don't retry simultaneously
+	half := int64(delay / 2)
+	if half <= 0 {
+		return delay
+	}
+	return time.Duration(half + rand.Int63n(half))
+}
+
+// Reset starts the backoff over after a success
+func (b *Backoff) Reset() {
+	b.attempt = 0
+}